@@ -0,0 +1,200 @@
+// Package acoustid identifies recordings by their Chromaprint fingerprint
+// using the AcoustID web service, or any server with the same API
+package acoustid
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultUrl = "https://api.acoustid.org/v2"
+
+var ErrInvalidApiKey = errors.New("invalid api key")
+
+// ApiError is an error reported by the AcoustID service
+type ApiError struct {
+	Code    int
+	Message string
+}
+
+func (err *ApiError) Error() string {
+	return fmt.Sprintf("AcoustID error %v: %v", err.Code, err.Message)
+}
+
+func (err *ApiError) Is(target error) bool {
+	// NOTE(patrik): https://github.com/acoustid/acoustid-server/blob/master/acoustid/api/errors.py
+	return target == ErrInvalidApiKey && err.Code == 4
+}
+
+type Artist struct {
+	Id         string `json:"id"`
+	Name       string `json:"name"`
+	JoinPhrase string `json:"joinphrase"`
+}
+
+type ReleaseGroup struct {
+	Id    string `json:"id"`
+	Title string `json:"title"`
+	Type  string `json:"type"`
+}
+
+type Recording struct {
+	Id       string  `json:"id"`
+	Title    string  `json:"title"`
+	Duration float64 `json:"duration"`
+
+	Artists       []Artist       `json:"artists"`
+	ReleaseGroups []ReleaseGroup `json:"releasegroups"`
+}
+
+// Credit joins the artists the way they are credited, e.g. "A feat. B"
+func (recording *Recording) Credit() string {
+	var b strings.Builder
+	for _, artist := range recording.Artists {
+		b.WriteString(artist.Name)
+		b.WriteString(artist.JoinPhrase)
+	}
+
+	return b.String()
+}
+
+// Result is one AcoustID track matching the fingerprint, Score is
+// between 0 and 1
+type Result struct {
+	Id         string      `json:"id"`
+	Score      float64     `json:"score"`
+	Recordings []Recording `json:"recordings"`
+}
+
+type lookupResponse struct {
+	Status  string   `json:"status"`
+	Results []Result `json:"results"`
+	Error   struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type Client struct {
+	httpClient *http.Client
+	baseUrl    string
+	apiKey     string
+
+	mu       sync.Mutex
+	interval time.Duration
+	last     time.Time
+}
+
+type Option func(client *Client)
+
+// WithBaseUrl sets the url of the web service, including the version
+// (e.g. https://api.acoustid.org/v2)
+func WithBaseUrl(url string) Option {
+	return func(client *Client) {
+		client.baseUrl = strings.TrimSuffix(url, "/")
+	}
+}
+
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(client *Client) {
+		client.httpClient = httpClient
+	}
+}
+
+// WithRateLimit sets the minimum time between requests, 0 disables the
+// limit
+func WithRateLimit(interval time.Duration) Option {
+	return func(client *Client) {
+		client.interval = interval
+	}
+}
+
+// NewClient creates a client using apiKey, an application key is needed
+// for lookups and can be registered at https://acoustid.org
+func NewClient(apiKey string, options ...Option) *Client {
+	client := &Client{
+		httpClient: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+		baseUrl: DefaultUrl,
+		apiKey:  apiKey,
+		// NOTE(patrik): AcoustID allows 3 requests per second
+		interval: time.Second / 3,
+	}
+
+	for _, option := range options {
+		option(client)
+	}
+
+	return client
+}
+
+func (client *Client) wait() {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	if wait := client.interval - time.Since(client.last); wait > 0 {
+		time.Sleep(wait)
+	}
+
+	client.last = time.Now()
+}
+
+// Lookup finds the recordings matching the fingerprint, results are
+// sorted by score with the best match first
+func (client *Client) Lookup(fp Fingerprint) ([]Result, error) {
+	form := url.Values{}
+	form.Set("client", client.apiKey)
+	form.Set("meta", "recordings releasegroups")
+	form.Set("duration", strconv.Itoa(int(fp.Duration)))
+	form.Set("fingerprint", fp.Fingerprint)
+	form.Set("format", "json")
+
+	client.wait()
+
+	// NOTE(patrik): Fingerprints are too long for a GET request
+	endpoint := client.baseUrl + "/lookup"
+	res, err := client.httpClient.PostForm(endpoint, form)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var response lookupResponse
+	err = json.Unmarshal(data, &response)
+	if err != nil {
+		return nil, fmt.Errorf("Malformed response from %v (%v): %w", endpoint, res.Status, err)
+	}
+
+	if response.Status != "ok" {
+		if response.Error.Message != "" {
+			return nil, &ApiError{
+				Code:    response.Error.Code,
+				Message: response.Error.Message,
+			}
+		}
+
+		return nil, fmt.Errorf("Lookup failed: %v", res.Status)
+	}
+
+	results := response.Results
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+
+	return results, nil
+}
@@ -0,0 +1,112 @@
+package acoustid
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/nanoteck137/dwebble-importer/utils"
+)
+
+const (
+	FingerprinterAuto   = "auto"
+	FingerprinterFpcalc = "fpcalc"
+	FingerprinterFFmpeg = "ffmpeg"
+)
+
+// Fingerprint is a Chromaprint fingerprint in the compressed base64 form
+// AcoustID expects, Duration is the length of the file in seconds
+type Fingerprint struct {
+	Duration    float64
+	Fingerprint string
+}
+
+// ResolveFingerprinter picks the program used to fingerprint files, auto
+// uses fpcalc when it's installed and ffmpeg otherwise
+func ResolveFingerprinter(name string) (string, error) {
+	switch name {
+	case FingerprinterFpcalc, FingerprinterFFmpeg:
+		return name, nil
+	case FingerprinterAuto, "":
+		if _, err := exec.LookPath("fpcalc"); err == nil {
+			return FingerprinterFpcalc, nil
+		}
+
+		return FingerprinterFFmpeg, nil
+	default:
+		return "", fmt.Errorf("Unknown fingerprinter '%v' (expected auto, fpcalc or ffmpeg)", name)
+	}
+}
+
+type fpcalcOutput struct {
+	Duration    float64 `json:"duration"`
+	Fingerprint string  `json:"fingerprint"`
+}
+
+func fingerprintFpcalc(ctx context.Context, filepath string) (Fingerprint, error) {
+	// fpcalc -json input
+	data, err := exec.CommandContext(ctx, "fpcalc", "-json", filepath).Output()
+	if err != nil {
+		return Fingerprint{}, fmt.Errorf("fpcalc failed for '%v': %w", filepath, err)
+	}
+
+	var output fpcalcOutput
+	err = json.Unmarshal(data, &output)
+	if err != nil {
+		return Fingerprint{}, err
+	}
+
+	return Fingerprint{
+		Duration:    output.Duration,
+		Fingerprint: output.Fingerprint,
+	}, nil
+}
+
+func fingerprintFFmpeg(ctx context.Context, filepath string) (Fingerprint, error) {
+	probe, err := utils.ProbeFile(filepath)
+	if err != nil {
+		return Fingerprint{}, err
+	}
+
+	// NOTE(patrik): Needs ffmpeg built with --enable-chromaprint
+	// ffmpeg -i input -f chromaprint -fp_format base64 -
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-v", "error", "-i", filepath, "-f", "chromaprint", "-fp_format", "base64", "-")
+
+	data, err := cmd.Output()
+	if err != nil {
+		return Fingerprint{}, fmt.Errorf("ffmpeg chromaprint failed for '%v': %w", filepath, err)
+	}
+
+	return Fingerprint{
+		Duration:    probe.Duration,
+		Fingerprint: strings.TrimSpace(string(data)),
+	}, nil
+}
+
+// FingerprintFile computes the fingerprint of a file with fingerprinter,
+// which is either FingerprinterFpcalc or FingerprinterFFmpeg
+func FingerprintFile(ctx context.Context, fingerprinter, filepath string) (Fingerprint, error) {
+	var fp Fingerprint
+	var err error
+
+	switch fingerprinter {
+	case FingerprinterFpcalc:
+		fp, err = fingerprintFpcalc(ctx, filepath)
+	case FingerprinterFFmpeg:
+		fp, err = fingerprintFFmpeg(ctx, filepath)
+	default:
+		return Fingerprint{}, fmt.Errorf("Unknown fingerprinter '%v'", fingerprinter)
+	}
+
+	if err != nil {
+		return Fingerprint{}, err
+	}
+
+	if fp.Fingerprint == "" {
+		return Fingerprint{}, fmt.Errorf("Empty fingerprint for '%v'", filepath)
+	}
+
+	return fp, nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/nanoteck137/dwebble-importer/coverart"
+	"github.com/nanoteck137/dwebble-importer/utils"
+)
+
+// PlanCover is a picture embedded in one of the source files
+type PlanCover struct {
+	SourceFile string              `json:"sourceFile"`
+	Picture    utils.PictureStream `json:"picture"`
+}
+
+// key identifies the picture, pictures with the same data have the same
+// key even when they are embedded in different files
+func (cover *PlanCover) key() string {
+	if cover.Picture.Hash != "" {
+		return cover.Picture.Codec + "-" + cover.Picture.Hash
+	}
+
+	return fmt.Sprintf("%v:%v", cover.SourceFile, cover.Picture.Index)
+}
+
+type coverOptions struct {
+	names     []string
+	normalize coverart.Options
+}
+
+// writeCover normalizes the image data and writes it to the work dir,
+// the file is named after its content so the same image is only stored
+// once
+func writeCover(workDir string, data []byte, opts coverart.Options) (string, error) {
+	data, format, err := coverart.Normalize(data, opts)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.Sum256(data)
+	p := path.Join(workDir, fmt.Sprintf("cover-%x.%v", hash[:8], coverart.Ext(format)))
+
+	err = os.WriteFile(p, data, 0644)
+	if err != nil {
+		return "", err
+	}
+
+	return p, nil
+}
+
+func prepareCoverFile(workDir, file string, opts coverart.Options) (string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+
+	p, err := writeCover(workDir, data, opts)
+	if err != nil {
+		return "", fmt.Errorf("Failed to prepare cover '%v': %w", file, err)
+	}
+
+	return p, nil
+}
+
+// extractCover extracts the embedded picture into the work dir and
+// normalizes it
+func extractCover(ctx context.Context, workDir string, cover *PlanCover, opts coverart.Options) (string, error) {
+	tmp := path.Join(workDir, "cover.tmp."+cover.Picture.Ext())
+	defer os.Remove(tmp)
+
+	err := utils.ExtractPicture(ctx, cover.SourceFile, cover.Picture, tmp)
+	if err != nil {
+		return "", fmt.Errorf("Failed to extract cover from '%v': %w", cover.SourceFile, err)
+	}
+
+	data, err := os.ReadFile(tmp)
+	if err != nil {
+		return "", err
+	}
+
+	p, err := writeCover(workDir, data, opts)
+	if err != nil {
+		return "", fmt.Errorf("Failed to prepare cover from '%v': %w", cover.SourceFile, err)
+	}
+
+	return p, nil
+}
+
+// prepareCovers writes the covers that are uploaded into the work dir.
+// The album uses the cover image from the album dir and falls back to
+// the embedded cover. Tracks use their embedded cover and fall back to
+// the album cover. Tracks usually embed the same picture so every
+// picture is only extracted once, pictures without a hash can't be
+// compared and are extracted from every file. A cover that can't be
+// used is skipped.
+//
+// NOTE(patrik): The server rejects tracks without a cover, so albums
+// without any usable cover get a placeholder
+func prepareCovers(ctx context.Context, plan *Plan, opts coverart.Options) error {
+	extracted := make(map[string]string)
+
+	embedded := func(cover *PlanCover) (string, error) {
+		if p, exists := extracted[cover.key()]; exists {
+			return p, nil
+		}
+
+		p, err := extractCover(ctx, plan.WorkDir, cover, opts)
+		if err != nil {
+			if ctx.Err() != nil {
+				return "", ctx.Err()
+			}
+
+			fmt.Printf("Skipping cover: %v\n", err)
+		}
+
+		extracted[cover.key()] = p
+		return p, nil
+	}
+
+	if plan.Album.CoverFile != "" {
+		p, err := prepareCoverFile(plan.WorkDir, plan.Album.CoverFile, opts)
+		if err != nil {
+			fmt.Printf("Skipping cover: %v\n", err)
+		}
+
+		plan.Album.CoverArt = p
+	}
+
+	if plan.Album.CoverArt == "" && plan.Album.Cover != nil {
+		p, err := embedded(plan.Album.Cover)
+		if err != nil {
+			return err
+		}
+
+		plan.Album.CoverArt = p
+	}
+
+	if plan.Album.CoverArt == "" {
+		data, err := coverart.Placeholder()
+		if err != nil {
+			return err
+		}
+
+		p, err := writeCover(plan.WorkDir, data, opts)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("No cover found, using a placeholder\n")
+		plan.Album.CoverArt = p
+	}
+
+	for i := range plan.Tracks {
+		track := &plan.Tracks[i]
+
+		if track.Cover != nil {
+			p, err := embedded(track.Cover)
+			if err != nil {
+				return err
+			}
+
+			track.CoverArt = p
+		}
+
+		if track.CoverArt == "" {
+			track.CoverArt = plan.Album.CoverArt
+		}
+	}
+
+	return nil
+}
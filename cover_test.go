@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/nanoteck137/dwebble-importer/utils"
+)
+
+func TestPlanCoverKey(t *testing.T) {
+	picture := utils.PictureStream{Index: 1, Codec: "mjpeg", Width: 500, Height: 500, Hash: "abc"}
+
+	a := PlanCover{SourceFile: "01.flac", Picture: picture}
+	b := PlanCover{SourceFile: "02.flac", Picture: picture}
+	if a.key() != b.key() {
+		t.Errorf("The same picture in two files got the keys %q and %q", a.key(), b.key())
+	}
+
+	// NOTE(patrik): Pictures without a hash can't be compared
+	picture.Hash = ""
+	a.Picture = picture
+	b.Picture = picture
+	if a.key() == b.key() {
+		t.Errorf("Pictures without a hash in two files got the same key %q", a.key())
+	}
+
+	other := PlanCover{SourceFile: "01.flac", Picture: utils.PictureStream{Index: 2, Codec: "png", Hash: "def"}}
+	a.Picture.Hash = "abc"
+	if a.key() == other.key() {
+		t.Errorf("Different pictures got the same key %q", a.key())
+	}
+}
@@ -0,0 +1,117 @@
+// Package coverart finds the cover images stored next to the audio files
+// and prepares them for upload
+package coverart
+
+import (
+	"os"
+	"path"
+	"strings"
+)
+
+// DefaultNames are the images looked for in an album dir, in order of
+// priority. Names are relative to the album dir and don't have an
+// extension, they are matched without caring about case
+var DefaultNames = []string{
+	"cover",
+	"folder",
+	"front",
+	"Scans/cover",
+	"Scans/front",
+	"Scans/folder",
+}
+
+// Exts are the image types that can be used as a cover, in order of
+// priority when there is more then one image with the same name
+var Exts = []string{".jpg", ".jpeg", ".png"}
+
+func isImageExt(ext string) bool {
+	for _, e := range Exts {
+		if strings.EqualFold(e, ext) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// lookup returns the entry of dir named name, ignoring case. An exact
+// match is preferred
+func lookup(entries []os.DirEntry, name string, dir bool) string {
+	found := ""
+	for _, entry := range entries {
+		if entry.IsDir() != dir {
+			continue
+		}
+
+		if entry.Name() == name {
+			return name
+		}
+
+		if found == "" && strings.EqualFold(entry.Name(), name) {
+			found = entry.Name()
+		}
+	}
+
+	return found
+}
+
+func findImage(dir, name string) string {
+	for _, part := range strings.Split(path.Dir(name), "/") {
+		if part == "." {
+			continue
+		}
+
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return ""
+		}
+
+		sub := lookup(entries, part, true)
+		if sub == "" {
+			return ""
+		}
+
+		dir = path.Join(dir, sub)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+
+	base := path.Base(name)
+
+	// NOTE(patrik): Names with an extension only match that file
+	if isImageExt(path.Ext(base)) {
+		if file := lookup(entries, base, false); file != "" {
+			return path.Join(dir, file)
+		}
+
+		return ""
+	}
+
+	for _, ext := range Exts {
+		if file := lookup(entries, base+ext, false); file != "" {
+			return path.Join(dir, file)
+		}
+	}
+
+	return ""
+}
+
+// Find returns the path of the first image in dir matching one of names,
+// see DefaultNames. Returns an empty string when there is no image
+func Find(dir string, names []string) string {
+	for _, name := range names {
+		name = strings.Trim(path.Clean(name), "/")
+		if name == "" || name == "." {
+			continue
+		}
+
+		if p := findImage(dir, name); p != "" {
+			return p
+		}
+	}
+
+	return ""
+}
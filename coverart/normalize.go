@@ -0,0 +1,167 @@
+package coverart
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+)
+
+const (
+	FormatJPEG = "jpeg"
+	FormatPNG  = "png"
+)
+
+// NOTE(patrik): Images are not made smaller then this to fit the size
+// limit, at that point the limit is most likely a mistake
+const minEdge = 128
+
+type Options struct {
+	// Max width and height in pixels, larger images are downscaled. 0
+	// disables the limit
+	MaxEdge int
+	// Max size of the encoded image in bytes. 0 disables the limit
+	MaxBytes int
+}
+
+var DefaultOptions = Options{
+	MaxEdge:  1200,
+	MaxBytes: 1 << 20,
+}
+
+// Ext returns the file extension for format
+func Ext(format string) string {
+	if format == FormatJPEG {
+		return "jpg"
+	}
+
+	return format
+}
+
+func (opts *Options) fits(width, height, size int) bool {
+	if opts.MaxEdge > 0 && max(width, height) > opts.MaxEdge {
+		return false
+	}
+
+	return opts.MaxBytes <= 0 || size <= opts.MaxBytes
+}
+
+// Normalize makes sure the image data is a JPEG or PNG within the limits
+// of opts, and returns the new data and its format. Images that already
+// fit are returned as is. Larger images are downscaled, PNGs are kept as
+// PNG when they fit and are otherwise encoded as JPEG with the highest
+// quality that fits, the image is made smaller until it does
+func Normalize(data []byte, opts Options) ([]byte, string, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("Unsupported image: %w", err)
+	}
+
+	if opts.fits(config.Width, config.Height, len(data)) {
+		return data, format, nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+
+	edge := max(config.Width, config.Height)
+	if opts.MaxEdge > 0 && edge > opts.MaxEdge {
+		edge = opts.MaxEdge
+		img = downscale(img, edge)
+	}
+
+	if format == FormatPNG {
+		var buf bytes.Buffer
+		err := png.Encode(&buf, img)
+		if err != nil {
+			return nil, "", err
+		}
+
+		if opts.MaxBytes <= 0 || buf.Len() <= opts.MaxBytes {
+			return buf.Bytes(), FormatPNG, nil
+		}
+	}
+
+	img = flatten(img)
+	for {
+		for quality := 90; quality >= 50; quality -= 10 {
+			var buf bytes.Buffer
+			err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+			if err != nil {
+				return nil, "", err
+			}
+
+			if opts.MaxBytes <= 0 || buf.Len() <= opts.MaxBytes {
+				return buf.Bytes(), FormatJPEG, nil
+			}
+		}
+
+		edge = edge * 3 / 4
+		if edge < minEdge {
+			return nil, "", fmt.Errorf("Image doesn't fit in %v bytes", opts.MaxBytes)
+		}
+
+		img = downscale(img, edge)
+	}
+}
+
+// downscale shrinks img so the longest side is edge pixels, every pixel
+// is the average of the pixels it covers in img
+func downscale(img image.Image, edge int) *image.RGBA {
+	bounds := img.Bounds()
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+
+	width, height := edge, edge
+	if srcWidth > srcHeight {
+		height = max(1, srcHeight*edge/srcWidth)
+	} else {
+		width = max(1, srcWidth*edge/srcHeight)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*srcHeight/height
+		y1 := bounds.Min.Y + max((y+1)*srcHeight/height, y*srcHeight/height+1)
+
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*srcWidth/width
+			x1 := bounds.Min.X + max((x+1)*srcWidth/width, x*srcWidth/width+1)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r += uint64(cr)
+					g += uint64(cg)
+					b += uint64(cb)
+					a += uint64(ca)
+					n++
+				}
+			}
+
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(b / n >> 8),
+				A: uint8(a / n >> 8),
+			})
+		}
+	}
+
+	return dst
+}
+
+// flatten draws img on a white background since JPEG has no
+// transparency
+func flatten(img image.Image) image.Image {
+	bounds := img.Bounds()
+	dst := image.NewRGBA(bounds)
+	draw.Draw(dst, bounds, image.White, image.Point{}, draw.Src)
+	draw.Draw(dst, bounds, img, bounds.Min, draw.Over)
+	return dst
+}
@@ -0,0 +1,27 @@
+package coverart
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+)
+
+// PlaceholderSize is the width and height of the placeholder cover
+const PlaceholderSize = 500
+
+// Placeholder returns a plain gray PNG, used for albums without any cover
+// since the server needs a cover for every track
+func Placeholder() ([]byte, error) {
+	img := image.NewGray(image.Rect(0, 0, PlaceholderSize, PlaceholderSize))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.Gray{Y: 0x80}), image.Point{}, draw.Src)
+
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
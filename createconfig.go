@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/nanoteck137/dwebble-importer/musicbrainz"
+	"github.com/nanoteck137/dwebble-importer/utils"
+	"github.com/pelletier/go-toml/v2"
+)
+
+type discFile struct {
+	disc     int
+	subtitle string
+	path     string
+}
+
+func collectTrackFiles(dir string, disc int, subtitle string) ([]discFile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []discFile
+
+	for _, entry := range entries {
+		p := path.Join(dir, entry.Name())
+
+		if entry.IsDir() {
+			// NOTE(patrik): Only look one level down for disc folders
+			if disc != 0 {
+				continue
+			}
+
+			num, sub, ok := utils.ParseDiscDir(entry.Name())
+			if !ok {
+				continue
+			}
+
+			res, err := collectTrackFiles(p, num, sub)
+			if err != nil {
+				return nil, err
+			}
+
+			files = append(files, res...)
+			continue
+		}
+
+		ext := path.Ext(p)
+		if ext != "" && utils.IsValidTrackExt(ext[1:]) {
+			files = append(files, discFile{
+				disc:     disc,
+				subtitle: subtitle,
+				path:     p,
+			})
+		}
+	}
+
+	return files, nil
+}
+
+type createConfigOptions struct {
+	// NOTE(patrik): Ask before overwriting an existing config, when false
+	// the directory is skipped instead
+	prompt bool
+
+	// MusicBrainz release used to fill in the metadata
+	mbid string
+
+	// Search MusicBrainz for the release, candidates with a confidence of
+	// at least autoAccept are used without asking
+	lookup     bool
+	autoAccept float64
+
+	// Max difference in seconds between a file and the MusicBrainz track
+	// before it's reported as a mismatch
+	tolerance float64
+
+	// Filename patterns tried in order to find the track number and the
+	// metadata missing from the tags
+	patterns []*utils.FilenamePattern
+
+	// Identify tracks without a title by their fingerprint, nil when
+	// disabled
+	fingerprint *fingerprintOptions
+
+	mb *musicbrainz.Client
+}
+
+// runCreateConfig generates album.toml for dir
+func runCreateConfig(dir string, opts createConfigOptions) error {
+	fmt.Printf("Dir: %v\n", dir)
+
+	// NOTE(patrik): Without a prompt an existing config is never
+	// overwritten, so skip before probing and looking up anything
+	configPath := path.Join(dir, "album.toml")
+	_, err := os.Stat(configPath)
+	configExists := !errors.Is(err, os.ErrNotExist)
+	if configExists && !opts.prompt {
+		return errSkipped
+	}
+
+	files, err := collectTrackFiles(dir, 0, "")
+	if err != nil {
+		return err
+	}
+
+	type fileResult struct {
+		utils.FileResult
+		disc     int
+		subtitle string
+	}
+
+	var fileResults []fileResult
+
+	for _, file := range files {
+		res, err := utils.CheckFile(file.path, opts.patterns)
+		if err != nil {
+			return err
+		}
+
+		disc := file.disc
+		if disc == 0 {
+			disc = res.Disc
+		}
+
+		if disc <= 0 {
+			disc = res.Probe.Disc
+		}
+
+		if disc <= 0 {
+			disc = 1
+		}
+
+		fileResults = append(fileResults, fileResult{
+			FileResult: res,
+			disc:       disc,
+			subtitle:   file.subtitle,
+		})
+	}
+
+	albumArtistName := ""
+	albumName := ""
+	var tracks []ConfigTrack
+	discs := make(map[int]string)
+	durations := make(map[string]float64)
+
+	for _, file := range fileResults {
+		if file.Probe.Track != -1 && file.Probe.Track != file.Number {
+			return fmt.Errorf("Track number not matching for '%v'", file.Path)
+		}
+
+		if _, exists := discs[file.disc]; !exists || file.subtitle != "" {
+			discs[file.disc] = file.subtitle
+		}
+
+		if file.Probe.AlbumArtist != "" {
+			albumArtistName = file.Probe.AlbumArtist
+		}
+
+		if file.Probe.Album != "" {
+			albumName = file.Probe.Album
+		} else if albumName == "" && file.Album != "" {
+			albumName = file.Album
+		}
+
+		filename, err := filepath.Rel(dir, file.Path)
+		if err != nil {
+			return err
+		}
+
+		durations[filepath.ToSlash(filename)] = file.Probe.Duration
+
+		if audio, ok := file.Probe.Audio(); ok {
+			fmt.Printf("  %v: %v, %v\n", filename, audio.String(), formatLength(file.Probe.Duration))
+		}
+
+		// NOTE(patrik): The tags are preferred, the filename is only used
+		// for untagged files
+		name := file.Probe.Title
+		if name == "" {
+			name = file.Name
+		}
+
+		artist := file.Probe.Artist
+		if artist == "" {
+			artist = file.Artist
+		}
+
+		tracks = append(tracks, ConfigTrack{
+			Num:      file.Number,
+			Disc:     file.disc,
+			Name:     name,
+			Filename: filepath.ToSlash(filename),
+			Artist:   artist,
+		})
+	}
+
+	sort.SliceStable(tracks, func(i, j int) bool {
+		if tracks[i].Disc != tracks[j].Disc {
+			return tracks[i].Disc < tracks[j].Disc
+		}
+
+		return tracks[i].Num < tracks[j].Num
+	})
+
+	for i := 1; i < len(tracks); i++ {
+		prev := tracks[i-1]
+		if prev.Disc == tracks[i].Disc && prev.Num == tracks[i].Num {
+			return fmt.Errorf("Duplicate track number %v on disc %v ('%v' and '%v')", prev.Num, prev.Disc, prev.Filename, tracks[i].Filename)
+		}
+	}
+
+	var configDiscs []ConfigDisc
+	if len(discs) > 1 {
+		for num, subtitle := range discs {
+			configDiscs = append(configDiscs, ConfigDisc{
+				Num:      num,
+				Subtitle: subtitle,
+			})
+		}
+
+		sort.Slice(configDiscs, func(i, j int) bool {
+			return configDiscs[i].Num < configDiscs[j].Num
+		})
+	}
+
+	config := Config{
+		Typ:    "",
+		Name:   albumName,
+		Artist: albumArtistName,
+		Discs:  configDiscs,
+		Tracks: tracks,
+	}
+
+	if opts.fingerprint != nil {
+		err := identifyTracks(context.Background(), dir, &config, opts.fingerprint)
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.mbid != "" {
+		metadata, err := opts.mb.FetchAlbumMetadata(opts.mbid)
+		if errors.Is(err, musicbrainz.ErrNotFound) {
+			return fmt.Errorf("Release '%v' not found on MusicBrainz", opts.mbid)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		err = applyRelease(&config, &metadata)
+		if err != nil {
+			return err
+		}
+
+		printDurationMismatches(compareDurations(&config, durations, &metadata, opts.tolerance))
+	} else if opts.lookup {
+		tocDirs := []string{dir}
+		for _, file := range files {
+			if d := path.Dir(file.path); d != tocDirs[len(tocDirs)-1] {
+				tocDirs = append(tocDirs, d)
+			}
+		}
+
+		// TODO(patrik): Only the first disc is used for the lookup, the
+		// other discs could be used to confirm the release
+		toc, tocFile := findDiscTOC(tocDirs)
+		if toc != nil {
+			fmt.Printf("Disc ID: %v (FreeDB: %v) from '%v'\n", toc.MusicBrainzId(), toc.FreeDBId(), tocFile)
+		}
+
+		metadata, err := lookupRelease(opts.mb, &config, durations, toc, opts.prompt, opts.autoAccept)
+		if err != nil {
+			return err
+		}
+
+		if metadata != nil {
+			err = applyRelease(&config, metadata)
+			if err != nil {
+				return err
+			}
+
+			printDurationMismatches(compareDurations(&config, durations, metadata, opts.tolerance))
+		}
+	}
+
+	data, err := toml.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(string(data))
+
+	if configExists {
+		reader := bufio.NewReader(os.Stdin)
+		fmt.Print("Config already exists overwrite (y/n): ")
+		text, _ := reader.ReadString('\n')
+		text = strings.TrimSpace(text)
+
+		switch text {
+		case "y", "yes":
+			fmt.Printf("Writing config\n")
+		default:
+			fmt.Printf("Not writing config\n")
+			return errSkipped
+		}
+	}
+
+	return os.WriteFile(configPath, data, 0644)
+}
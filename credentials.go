@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/nanoteck137/dwebble-importer/server"
+	"github.com/pelletier/go-toml/v2"
+	"github.com/spf13/cobra"
+)
+
+// Credentials for the dwebble server, either a token or a username and
+// password pair
+type Credentials struct {
+	Token    string `toml:"token"`
+	Username string `toml:"username"`
+	Password string `toml:"password"`
+}
+
+func defaultCredentialsPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+
+	return path.Join(dir, "dwebble-import", "credentials.toml")
+}
+
+func readCredentialsFile(p string, required bool) (Credentials, error) {
+	data, err := os.ReadFile(p)
+	if err != nil {
+		if !required && errors.Is(err, os.ErrNotExist) {
+			return Credentials{}, nil
+		}
+
+		return Credentials{}, err
+	}
+
+	var creds Credentials
+	err = toml.Unmarshal(data, &creds)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("%v: %w", p, err)
+	}
+
+	return creds, nil
+}
+
+func mergeCredentials(dst *Credentials, src Credentials) {
+	if src.Token != "" {
+		dst.Token = src.Token
+	}
+
+	if src.Username != "" {
+		dst.Username = src.Username
+	}
+
+	if src.Password != "" {
+		dst.Password = src.Password
+	}
+}
+
+// loadCredentials reads the credentials from the credentials file, the
+// environment and the flags of cmd, in that order with later sources
+// overriding earlier ones
+func loadCredentials(cmd *cobra.Command) (Credentials, error) {
+	credentialsPath, _ := cmd.Flags().GetString("credentials")
+	required := credentialsPath != ""
+	if credentialsPath == "" {
+		credentialsPath = defaultCredentialsPath()
+	}
+
+	var creds Credentials
+
+	if credentialsPath != "" {
+		res, err := readCredentialsFile(credentialsPath, required)
+		if err != nil {
+			return Credentials{}, err
+		}
+
+		mergeCredentials(&creds, res)
+	}
+
+	mergeCredentials(&creds, Credentials{
+		Token:    os.Getenv("DWEBBLE_TOKEN"),
+		Username: os.Getenv("DWEBBLE_USERNAME"),
+		Password: os.Getenv("DWEBBLE_PASSWORD"),
+	})
+
+	token, _ := cmd.Flags().GetString("token")
+	username, _ := cmd.Flags().GetString("username")
+	password, _ := cmd.Flags().GetString("password")
+
+	mergeCredentials(&creds, Credentials{
+		Token:    token,
+		Username: username,
+		Password: password,
+	})
+
+	if creds.Username != "" && creds.Password == "" {
+		return Credentials{}, errors.New("Username set without a password")
+	}
+
+	return creds, nil
+}
+
+func (creds *Credentials) Options() []server.Option {
+	var options []server.Option
+
+	if creds.Token != "" {
+		options = append(options, server.WithToken(creds.Token))
+	}
+
+	if creds.Username != "" {
+		options = append(options, server.WithLogin(creds.Username, creds.Password))
+	}
+
+	return options
+}
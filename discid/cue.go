@@ -0,0 +1,205 @@
+package discid
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type CueTrack struct {
+	Number int
+	File   string
+	Audio  bool
+
+	// Start of the track inside File in sectors (INDEX 01)
+	Index01 int
+	// Silence not stored in any file before the track (PREGAP)
+	Pregap int
+}
+
+type Cue struct {
+	Files  []string
+	Tracks []CueTrack
+}
+
+// parseCueTime parses mm:ss:ff where ff is sectors
+func parseCueTime(s string) (int, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("Invalid cue time '%v'", s)
+	}
+
+	var values [3]int
+	for i, part := range parts {
+		value, err := strconv.Atoi(part)
+		if err != nil {
+			return 0, fmt.Errorf("Invalid cue time '%v'", s)
+		}
+
+		values[i] = value
+	}
+
+	return (values[0]*60+values[1])*SectorsPerSecond + values[2], nil
+}
+
+// parseCueFile returns the file name of a FILE line, the name can be
+// quoted and is followed by the file type
+func parseCueFile(rest string) string {
+	rest = strings.TrimSpace(rest)
+	if strings.HasPrefix(rest, `"`) {
+		if end := strings.Index(rest[1:], `"`); end != -1 {
+			return rest[1 : end+1]
+		}
+	}
+
+	if i := strings.LastIndex(rest, " "); i != -1 {
+		return rest[:i]
+	}
+
+	return rest
+}
+
+func ParseCue(data []byte) (*Cue, error) {
+	cue := &Cue{}
+
+	var current *CueTrack
+	file := ""
+
+	scanner := bufio.NewScanner(strings.NewReader(decodeText(data)))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		command, rest, _ := strings.Cut(line, " ")
+
+		switch strings.ToUpper(command) {
+		case "FILE":
+			file = parseCueFile(rest)
+			cue.Files = append(cue.Files, file)
+		case "TRACK":
+			fields := strings.Fields(rest)
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("Invalid cue line '%v'", line)
+			}
+
+			num, err := strconv.Atoi(fields[0])
+			if err != nil {
+				return nil, fmt.Errorf("Invalid cue line '%v'", line)
+			}
+
+			if file == "" {
+				return nil, fmt.Errorf("Cue track %v has no file", num)
+			}
+
+			cue.Tracks = append(cue.Tracks, CueTrack{
+				Number:  num,
+				File:    file,
+				Audio:   strings.EqualFold(fields[1], "AUDIO"),
+				Index01: -1,
+			})
+			current = &cue.Tracks[len(cue.Tracks)-1]
+		case "PREGAP":
+			if current == nil {
+				continue
+			}
+
+			pregap, err := parseCueTime(rest)
+			if err != nil {
+				return nil, err
+			}
+
+			current.Pregap = pregap
+		case "INDEX":
+			fields := strings.Fields(rest)
+			if current == nil || len(fields) != 2 || fields[0] != "01" {
+				continue
+			}
+
+			index, err := parseCueTime(fields[1])
+			if err != nil {
+				return nil, err
+			}
+
+			// NOTE(patrik): With one file per track the gap is often
+			// stored at the end of the previous file, the FILE line then
+			// comes between TRACK and INDEX 01 and the track starts in the
+			// new file
+			current.File = file
+			current.Index01 = index
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(cue.Tracks) == 0 {
+		return nil, fmt.Errorf("Cue sheet has no tracks")
+	}
+
+	for _, track := range cue.Tracks {
+		if track.Index01 < 0 {
+			return nil, fmt.Errorf("Cue track %v has no INDEX 01", track.Number)
+		}
+	}
+
+	return cue, nil
+}
+
+// TOC computes the TOC of the disc the cue sheet was made from, the
+// cue sheet has no lengths so fileLength needs to return the length of
+// each file in sectors. Data tracks at the end of the disc are left out
+func (cue *Cue) TOC(fileLength func(file string) (int, error)) (*TOC, error) {
+	fileStart := make(map[string]int)
+
+	total := 0
+	for _, file := range cue.Files {
+		if _, exists := fileStart[file]; exists {
+			continue
+		}
+
+		length, err := fileLength(file)
+		if err != nil {
+			return nil, err
+		}
+
+		fileStart[file] = total
+		total += length
+	}
+
+	tracks := cue.Tracks
+	for len(tracks) > 0 && !tracks[len(tracks)-1].Audio {
+		tracks = tracks[:len(tracks)-1]
+	}
+
+	if len(tracks) == 0 {
+		return nil, fmt.Errorf("Cue sheet has no audio tracks")
+	}
+
+	toc := &TOC{
+		FirstTrack: tracks[0].Number,
+		LastTrack:  tracks[len(tracks)-1].Number,
+	}
+
+	pregap := 0
+	for _, track := range tracks {
+		pregap += track.Pregap
+		toc.Offsets = append(toc.Offsets, LeadIn+pregap+fileStart[track.File]+track.Index01)
+	}
+
+	// TODO(patrik): Data tracks in the middle of the files would end up
+	// in the lead-out
+	toc.LeadOut = LeadIn + pregap + total
+
+	if len(tracks) != len(cue.Tracks) {
+		// NOTE(patrik): The data track files are part of the total, the
+		// audio session ends where the first data track starts
+		data := cue.Tracks[len(tracks)]
+		toc.LeadOut = LeadIn + pregap + fileStart[data.File] + data.Index01
+	}
+
+	if err := toc.validate(); err != nil {
+		return nil, err
+	}
+
+	return toc, nil
+}
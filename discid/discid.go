@@ -0,0 +1,125 @@
+// Package discid computes the MusicBrainz Disc ID and the FreeDB ID of a
+// CD from its table of contents, the TOC can be read from EAC/XLD logs or
+// from CUE sheets
+package discid
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// NOTE(patrik): Audio CDs have 75 sectors per second and the first track
+// starts after a 2 second lead-in
+const (
+	SectorsPerSecond = 75
+	LeadIn           = 150
+
+	// Gap between the audio session and the data session of an enhanced
+	// CD, the data track isn't part of the disc id
+	dataSessionGap = 11400
+)
+
+// TOC is the table of contents of a CD, the offsets are absolute sector
+// addresses so they include the lead-in
+type TOC struct {
+	FirstTrack int
+	LastTrack  int
+	LeadOut    int
+	Offsets    []int
+}
+
+func (toc *TOC) validate() error {
+	if len(toc.Offsets) == 0 {
+		return fmt.Errorf("TOC has no tracks")
+	}
+
+	if len(toc.Offsets) > 99 {
+		return fmt.Errorf("TOC has too many tracks (%v)", len(toc.Offsets))
+	}
+
+	if toc.LastTrack-toc.FirstTrack+1 != len(toc.Offsets) {
+		return fmt.Errorf("TOC track range %v-%v doesn't match %v offsets", toc.FirstTrack, toc.LastTrack, len(toc.Offsets))
+	}
+
+	prev := 0
+	for i, offset := range toc.Offsets {
+		if offset < prev {
+			return fmt.Errorf("TOC offset for track %v goes backwards", toc.FirstTrack+i)
+		}
+
+		prev = offset
+	}
+
+	if toc.LeadOut <= prev {
+		return fmt.Errorf("TOC lead-out is before the last track")
+	}
+
+	return nil
+}
+
+// MusicBrainzId computes the disc id as described in
+// https://musicbrainz.org/doc/Disc_ID_Calculation
+func (toc *TOC) MusicBrainzId() string {
+	h := sha1.New()
+
+	fmt.Fprintf(h, "%02X", toc.FirstTrack)
+	fmt.Fprintf(h, "%02X", toc.LastTrack)
+	fmt.Fprintf(h, "%08X", toc.LeadOut)
+
+	// NOTE(patrik): Always 99 track offsets, missing tracks are 0
+	for i := 0; i < 99; i++ {
+		offset := 0
+		if i < len(toc.Offsets) {
+			offset = toc.Offsets[i]
+		}
+
+		fmt.Fprintf(h, "%08X", offset)
+	}
+
+	id := base64.StdEncoding.EncodeToString(h.Sum(nil))
+
+	// NOTE(patrik): MusicBrainz uses its own url safe alphabet
+	return strings.NewReplacer("+", ".", "/", "_", "=", "-").Replace(id)
+}
+
+func digitSum(n int) int {
+	sum := 0
+	for n > 0 {
+		sum += n % 10
+		n /= 10
+	}
+
+	return sum
+}
+
+// FreeDBId computes the CDDB/FreeDB disc id
+func (toc *TOC) FreeDBId() string {
+	n := 0
+	for _, offset := range toc.Offsets {
+		n += digitSum(offset / SectorsPerSecond)
+	}
+
+	t := toc.LeadOut/SectorsPerSecond - toc.Offsets[0]/SectorsPerSecond
+	id := (n%255)<<24 | t<<8 | len(toc.Offsets)
+
+	return fmt.Sprintf("%08x", id)
+}
+
+// String returns the TOC in the format used by the MusicBrainz web
+// service, "first last leadout offset1 offset2 ..."
+func (toc *TOC) String() string {
+	parts := []string{
+		strconv.Itoa(toc.FirstTrack),
+		strconv.Itoa(toc.LastTrack),
+		strconv.Itoa(toc.LeadOut),
+	}
+
+	for _, offset := range toc.Offsets {
+		parts = append(parts, strconv.Itoa(offset))
+	}
+
+	return strings.Join(parts, " ")
+}
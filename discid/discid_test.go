@@ -0,0 +1,112 @@
+package discid
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+// NOTE(patrik): The TOC of the Metallica CD used by the mbtest fixtures
+var metallica = TOC{
+	FirstTrack: 1,
+	LastTrack:  12,
+	LeadOut:    281742,
+	Offsets: []int{
+		150, 24995, 49340, 66405, 95410, 125710,
+		143705, 161965, 191087, 210342, 233497, 264207,
+	},
+}
+
+const (
+	metallicaDiscId = "WqojhGSt9rnDPUrbTm5rR6yx7KA-"
+	metallicaFreeDB = "a80eaa0c"
+)
+
+// metallicaFileLength returns the length of the files in the cue sheets
+// in testdata, which are the tracks of the Metallica CD
+func metallicaFileLength(file string) (int, error) {
+	var num int
+	if _, err := fmt.Sscanf(file, "%02d.flac", &num); err == nil && num >= 1 && num <= len(metallica.Offsets) {
+		end := metallica.LeadOut
+		if num < len(metallica.Offsets) {
+			end = metallica.Offsets[num]
+		}
+
+		return end - metallica.Offsets[num-1], nil
+	}
+
+	switch file {
+	case "Metallica - Metallica.flac":
+		return metallica.LeadOut - LeadIn, nil
+	case "data.bin":
+		return 30000, nil
+	}
+
+	return 0, fmt.Errorf("Unknown file '%v'", file)
+}
+
+func TestTOC(t *testing.T) {
+	tests := []struct {
+		file string
+		read func(data []byte) (*TOC, error)
+	}{
+		// NOTE(patrik): EAC writes UTF-16 logs
+		{"eac.log", ParseLog},
+		{"xld.log", ParseLog},
+		// NOTE(patrik): The data track after the session gap is left out
+		{"enhanced.log", ParseLog},
+		{"multi.cue", readCueTOC},
+		{"enhanced.cue", readCueTOC},
+	}
+
+	for _, test := range tests {
+		t.Run(test.file, func(t *testing.T) {
+			data, err := os.ReadFile(path.Join("testdata", test.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			toc, err := test.read(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(*toc, metallica) {
+				t.Errorf("TOC = %v, want %v", toc, &metallica)
+			}
+
+			if id := toc.MusicBrainzId(); id != metallicaDiscId {
+				t.Errorf("MusicBrainzId = %v, want %v", id, metallicaDiscId)
+			}
+
+			if id := toc.FreeDBId(); id != metallicaFreeDB {
+				t.Errorf("FreeDBId = %v, want %v", id, metallicaFreeDB)
+			}
+		})
+	}
+}
+
+func readCueTOC(data []byte) (*TOC, error) {
+	cue, err := ParseCue(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return cue.TOC(metallicaFileLength)
+}
+
+func TestTOCString(t *testing.T) {
+	want := "1 12 281742 150 24995 49340 66405 95410 125710 143705 161965 191087 210342 233497 264207"
+	if got := metallica.String(); got != want {
+		t.Errorf("String = %q, want %q", got, want)
+	}
+}
+
+func TestParseLogInvalid(t *testing.T) {
+	_, err := ParseLog([]byte("Exact Audio Copy V1.6\n\nNo TOC here\n"))
+	if err == nil {
+		t.Fatal("Expected an error")
+	}
+}
@@ -0,0 +1,117 @@
+package discid
+
+import (
+	"bufio"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// decodeText returns the text of a log or cue file, EAC writes its logs
+// as UTF-16 with a byte order mark
+func decodeText(data []byte) string {
+	switch {
+	case len(data) >= 2 && data[0] == 0xff && data[1] == 0xfe:
+		return decodeUTF16(data[2:], false)
+	case len(data) >= 2 && data[0] == 0xfe && data[1] == 0xff:
+		return decodeUTF16(data[2:], true)
+	case len(data) >= 3 && data[0] == 0xef && data[1] == 0xbb && data[2] == 0xbf:
+		return string(data[3:])
+	}
+
+	return string(data)
+}
+
+func decodeUTF16(data []byte, bigEndian bool) string {
+	units := make([]uint16, 0, len(data)/2)
+	for i := 0; i+1 < len(data); i += 2 {
+		if bigEndian {
+			units = append(units, uint16(data[i])<<8|uint16(data[i+1]))
+		} else {
+			units = append(units, uint16(data[i+1])<<8|uint16(data[i]))
+		}
+	}
+
+	return string(utf16.Decode(units))
+}
+
+// NOTE(patrik): Matches the rows of the TOC table in both EAC and XLD
+// logs, only the time format differs between them
+//
+//	Track |   Start  |  Length  | Start sector | End sector
+//	    1  |  0:00.00 |  5:31.25 |         0    |    24874
+var logTocRow = regexp.MustCompile(`^\s*(\d+)\s*\|\s*[\d:.]+\s*\|\s*[\d:.]+\s*\|\s*(\d+)\s*\|\s*(\d+)\s*$`)
+
+type logTrack struct {
+	num   int
+	start int
+	end   int
+}
+
+// ParseLog reads the TOC from an EAC or XLD log, the data track of an
+// enhanced CD is left out the same way MusicBrainz does
+func ParseLog(data []byte) (*TOC, error) {
+	var tracks []logTrack
+
+	scanner := bufio.NewScanner(strings.NewReader(decodeText(data)))
+	for scanner.Scan() {
+		res := logTocRow.FindStringSubmatch(scanner.Text())
+		if res == nil {
+			// NOTE(patrik): Only the first table, logs with multiple
+			// TOCs are not supported
+			if len(tracks) > 0 && strings.TrimSpace(scanner.Text()) == "" {
+				break
+			}
+
+			continue
+		}
+
+		num, _ := strconv.Atoi(res[1])
+		start, _ := strconv.Atoi(res[2])
+		end, _ := strconv.Atoi(res[3])
+
+		tracks = append(tracks, logTrack{
+			num:   num,
+			start: start,
+			end:   end,
+		})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(tracks) == 0 {
+		return nil, fmt.Errorf("No TOC found in log")
+	}
+
+	leadOut := tracks[len(tracks)-1].end + 1 + LeadIn
+
+	if len(tracks) > 1 {
+		last := tracks[len(tracks)-1]
+		prev := tracks[len(tracks)-2]
+
+		if last.start-(prev.end+1) == dataSessionGap {
+			tracks = tracks[:len(tracks)-1]
+			leadOut = last.start - dataSessionGap + LeadIn
+		}
+	}
+
+	toc := &TOC{
+		FirstTrack: tracks[0].num,
+		LastTrack:  tracks[len(tracks)-1].num,
+		LeadOut:    leadOut,
+	}
+
+	for _, track := range tracks {
+		toc.Offsets = append(toc.Offsets, track.start+LeadIn)
+	}
+
+	if err := toc.validate(); err != nil {
+		return nil, err
+	}
+
+	return toc, nil
+}
@@ -0,0 +1,30 @@
+PERFORMER "Metallica"
+TITLE "Metallica"
+FILE "Metallica - Metallica.flac" WAVE
+  TRACK 01 AUDIO
+    INDEX 01 00:00:00
+  TRACK 02 AUDIO
+    INDEX 01 05:31:20
+  TRACK 03 AUDIO
+    INDEX 01 10:55:65
+  TRACK 04 AUDIO
+    INDEX 01 14:43:30
+  TRACK 05 AUDIO
+    INDEX 01 21:10:10
+  TRACK 06 AUDIO
+    INDEX 01 27:54:10
+  TRACK 07 AUDIO
+    INDEX 01 31:54:05
+  TRACK 08 AUDIO
+    INDEX 01 35:57:40
+  TRACK 09 AUDIO
+    INDEX 01 42:25:62
+  TRACK 10 AUDIO
+    INDEX 01 46:42:42
+  TRACK 11 AUDIO
+    INDEX 01 51:51:22
+  TRACK 12 AUDIO
+    INDEX 01 58:40:57
+FILE "data.bin" BINARY
+  TRACK 13 MODE2/2352
+    INDEX 01 00:00:00
@@ -0,0 +1,53 @@
+REM GENRE Metal
+REM DATE 1991
+PERFORMER "Metallica"
+TITLE "Metallica"
+FILE "01.flac" WAVE
+  TRACK 01 AUDIO
+    TITLE "Track 1"
+    INDEX 01 00:00:00
+FILE "02.flac" WAVE
+  TRACK 02 AUDIO
+    TITLE "Track 2"
+    INDEX 01 00:00:00
+FILE "03.flac" WAVE
+  TRACK 03 AUDIO
+    TITLE "Track 3"
+    INDEX 01 00:00:00
+FILE "04.flac" WAVE
+  TRACK 04 AUDIO
+    TITLE "Track 4"
+    INDEX 01 00:00:00
+  TRACK 05 AUDIO
+    TITLE "Track 5"
+    INDEX 00 06:24:55
+FILE "05.flac" WAVE
+    INDEX 01 00:00:00
+FILE "06.flac" WAVE
+  TRACK 06 AUDIO
+    TITLE "Track 6"
+    INDEX 01 00:00:00
+FILE "07.flac" WAVE
+  TRACK 07 AUDIO
+    TITLE "Track 7"
+    INDEX 01 00:00:00
+FILE "08.flac" WAVE
+  TRACK 08 AUDIO
+    TITLE "Track 8"
+    INDEX 01 00:00:00
+FILE "09.flac" WAVE
+  TRACK 09 AUDIO
+    TITLE "Track 9"
+    INDEX 01 00:00:00
+FILE "10.flac" WAVE
+  TRACK 10 AUDIO
+    TITLE "Track 10"
+    INDEX 01 00:00:00
+FILE "11.flac" WAVE
+  TRACK 11 AUDIO
+    TITLE "Track 11"
+    INDEX 01 00:00:00
+FILE "12.flac" WAVE
+  TRACK 12 AUDIO
+    TITLE "Track 12"
+    INDEX 01 00:00:00
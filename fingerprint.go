@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"path"
+
+	"github.com/nanoteck137/dwebble-importer/acoustid"
+)
+
+// NOTE(patrik): Matches below this score are too often the wrong song
+const minFingerprintScore = 0.7
+
+type fingerprintOptions struct {
+	fingerprinter string
+	client        *acoustid.Client
+}
+
+// bestRecording picks the recording of the best result above the score
+// limit, when a result has more then one recording the one closest to
+// the length of the file is used
+func bestRecording(results []acoustid.Result, duration float64) (*acoustid.Recording, float64, bool) {
+	for _, result := range results {
+		if result.Score < minFingerprintScore {
+			break
+		}
+
+		var best *acoustid.Recording
+		for i := range result.Recordings {
+			recording := &result.Recordings[i]
+			if recording.Title == "" {
+				continue
+			}
+
+			if best == nil || math.Abs(recording.Duration-duration) < math.Abs(best.Duration-duration) {
+				best = recording
+			}
+		}
+
+		if best != nil {
+			return best, result.Score, true
+		}
+	}
+
+	return nil, 0, false
+}
+
+// mostCommon returns the value with the most votes, ties go to the value
+// that got its first vote first
+func mostCommon(values []string) string {
+	counts := make(map[string]int)
+	best := ""
+	for _, value := range values {
+		counts[value]++
+		if counts[value] > counts[best] || best == "" {
+			best = value
+		}
+	}
+
+	return best
+}
+
+// identifyTracks fingerprints every track without a title and fills in
+// the title, artists and recording id from the best AcoustID match. The
+// album name and artist are filled in from the matches if they are
+// missing
+func identifyTracks(ctx context.Context, dir string, config *Config, opts *fingerprintOptions) error {
+	var albumNames []string
+	var albumArtists []string
+
+	for i := range config.Tracks {
+		track := &config.Tracks[i]
+		if track.Name != "" {
+			continue
+		}
+
+		fp, err := acoustid.FingerprintFile(ctx, opts.fingerprinter, path.Join(dir, track.Filename))
+		if err != nil {
+			return err
+		}
+
+		results, err := opts.client.Lookup(fp)
+		if err != nil {
+			return err
+		}
+
+		recording, score, found := bestRecording(results, fp.Duration)
+		if !found {
+			fmt.Printf("No fingerprint match for '%v'\n", track.Filename)
+			continue
+		}
+
+		track.Name = recording.Title
+		track.RecordingMbid = recording.Id
+		track.Artist = recording.Credit()
+		track.Artists = nil
+		track.ArtistMbids = nil
+		for _, artist := range recording.Artists {
+			track.Artists = append(track.Artists, artist.Name)
+			track.ArtistMbids = append(track.ArtistMbids, artist.Id)
+		}
+
+		fmt.Printf("Identified '%v' as '%v' by '%v' (%.2f)\n", track.Filename, track.Name, track.Artist, score)
+
+		albumArtists = append(albumArtists, track.Artist)
+		for _, group := range recording.ReleaseGroups {
+			albumNames = append(albumNames, group.Title)
+		}
+	}
+
+	if config.Name == "" && len(albumNames) > 0 {
+		config.Name = mostCommon(albumNames)
+	}
+
+	if config.Artist == "" && len(albumArtists) > 0 {
+		config.Artist = mostCommon(albumArtists)
+	}
+
+	return nil
+}
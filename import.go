@@ -0,0 +1,397 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/nanoteck137/dwebble-importer/server"
+	"github.com/pelletier/go-toml/v2"
+)
+
+func readConfig(dir string) (Config, error) {
+	p := path.Join(dir, "album.toml")
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return Config{}, err
+	}
+
+	var config Config
+	err = toml.Unmarshal(data, &config)
+	if err != nil {
+		return Config{}, fmt.Errorf("%v: %w", p, err)
+	}
+
+	return config, nil
+}
+
+func getContentTypeFromExt(ext string) (string, error) {
+	switch ext {
+	case "flac":
+		return "audio/flac", nil
+	case "mp3":
+		return "audio/mpeg", nil
+	case "ogg", "opus":
+		return "audio/ogg", nil
+	case "png":
+		return "image/png", nil
+	case "jpg", "jpeg":
+		return "image/jpeg", nil
+	default:
+		return "", fmt.Errorf("Unsupported ext '%v'", ext)
+	}
+}
+
+func createFile(filePath string) (server.File, error) {
+	ext := path.Ext(filePath)
+	if ext == "" {
+		return server.File{}, fmt.Errorf("File '%v' has no extention", filePath)
+	}
+
+	contentType, err := getContentTypeFromExt(ext[1:])
+	if err != nil {
+		return server.File{}, err
+	}
+
+	content, err := os.Open(filePath)
+	if err != nil {
+		return server.File{}, err
+	}
+
+	return server.File{
+		ContentType: contentType,
+		Name:        path.Base(filePath),
+		Content:     content,
+	}, nil
+}
+
+func closeFile(file server.File) {
+	if f, ok := file.Content.(*os.File); ok {
+		f.Close()
+	}
+}
+
+// executePlan creates the artists and the album from the plan on the
+// server, the created ids are recorded in the journal
+func executePlan(ctx context.Context, api *server.Server, plan *Plan, journal *Journal) error {
+	for _, artist := range plan.Artists {
+		if _, exists := journal.Artists[artist.Name]; exists {
+			continue
+		}
+
+		if artist.Action == ActionExisting {
+			journal.Artists[artist.Name] = artist.Id
+			continue
+		}
+
+		artistId, err := createArtist(ctx, api, artist.Name, artist.Mbid)
+		if err != nil {
+			return err
+		}
+
+		journal.Artists[artist.Name] = artistId
+		err = journal.Save()
+		if err != nil {
+			return err
+		}
+	}
+
+	if journal.AlbumId != "" {
+		return nil
+	}
+
+	if plan.Album.Action == ActionExisting {
+		journal.AlbumId = plan.Album.Id
+		return journal.Save()
+	}
+
+	artistId, extraArtistIds := journal.ArtistIds(plan.Album.Artists)
+	albumId, err := createAlbum(ctx, api, &plan.Album, artistId, extraArtistIds)
+	if err != nil {
+		return err
+	}
+
+	journal.AlbumId = albumId
+	return journal.Save()
+}
+
+// createArtist creates a new artist, if the server reports that the
+// artist already exists the existing artist is used instead
+func createArtist(ctx context.Context, api *server.Server, name, mbid string) (string, error) {
+	res, err := api.CreateArtist(ctx, server.ArtistData{
+		Name:    name,
+		Mbid:    mbid,
+		Picture: nil,
+	})
+	if err == nil {
+		return res.Id, nil
+	}
+
+	if !errors.Is(err, server.ErrConflict) {
+		return "", err
+	}
+
+	existing, lookupErr := planArtist(ctx, api, name, mbid)
+	if lookupErr != nil || existing.Action != ActionExisting {
+		return "", err
+	}
+
+	return existing.Id, nil
+}
+
+// createAlbum creates a new album, if the server reports that the album
+// already exists the existing album is used instead
+func createAlbum(ctx context.Context, api *server.Server, album *PlanAlbum, artistId string, extraArtistIds []string) (string, error) {
+	var coverArt server.File
+	if album.CoverArt != "" {
+		var err error
+		coverArt, err = createFile(album.CoverArt)
+		if err != nil {
+			return "", err
+		}
+		defer closeFile(coverArt)
+	}
+
+	res, err := api.CreateAlbum(ctx, server.AlbumData{
+		Name:             album.Name,
+		ArtistId:         artistId,
+		ExtraArtistIds:   extraArtistIds,
+		CoverArt:         coverArt,
+		Mbid:             album.Mbid,
+		ReleaseGroupMbid: album.ReleaseGroupMbid,
+	})
+	if err == nil {
+		return res.Id, nil
+	}
+
+	if !errors.Is(err, server.ErrConflict) {
+		return "", err
+	}
+
+	albums, lookupErr := api.GetArtistAlbums(ctx, artistId, album.Name)
+	if lookupErr != nil {
+		return "", err
+	}
+
+	mbids := make([]string, 0, len(albums.Albums))
+	for _, existing := range albums.Albums {
+		mbids = append(mbids, existing.Mbid)
+	}
+
+	index, ambiguous := matchMbid(mbids, album.Mbid)
+	if ambiguous || index == -1 {
+		return "", err
+	}
+
+	return albums.Albums[index].Id, nil
+}
+
+func uploadTrack(ctx context.Context, api *server.Server, track ProcessedTrack) (string, error) {
+	bestQualityFile, err := createFile(track.BestQualityFile)
+	if err != nil {
+		return "", err
+	}
+	defer closeFile(bestQualityFile)
+
+	mobileQualityFile, err := createFile(track.MobileQualityFile)
+	if err != nil {
+		return "", err
+	}
+	defer closeFile(mobileQualityFile)
+
+	var coverArt server.File
+	if track.CoverArt != "" {
+		coverArt, err = createFile(track.CoverArt)
+		if err != nil {
+			return "", err
+		}
+		defer closeFile(coverArt)
+	}
+
+	res, err := api.CreateTrack(ctx, server.TrackData{
+		Name:              track.Name,
+		Number:            track.Number,
+		Disc:              track.Disc,
+		AlbumId:           track.AlbumId,
+		ArtistId:          track.ArtistId,
+		ExtraArtistIds:    track.ExtraArtistIds,
+		RecordingMbid:     track.RecordingMbid,
+		Duration:          track.Duration,
+		BestQualityFile:   bestQualityFile,
+		MobileQualityFile: mobileQualityFile,
+		CoverArt:          coverArt,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return res.Id, nil
+}
+
+// existingTracks returns the ids of the tracks the server already has
+// on the album keyed by their number
+func existingTracks(ctx context.Context, api *server.Server, albumId string) (map[int]string, error) {
+	res, err := api.GetAlbumTracks(ctx, albumId)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get the tracks of album '%v': %w", albumId, err)
+	}
+
+	tracks := make(map[int]string)
+	for _, track := range res.Tracks {
+		tracks[int(track.Number)] = track.Id
+	}
+
+	return tracks, nil
+}
+
+type importOptions struct {
+	stateDir string
+	resume   bool
+	jobs     int
+	covers   coverOptions
+}
+
+func runImport(ctx context.Context, api *server.Server, dir string, opts importOptions) error {
+	stateDir, err := albumStateDir(opts.stateDir, dir)
+	if err != nil {
+		return err
+	}
+
+	journal, exists, err := openJournal(stateDir, dir)
+	if err != nil {
+		return err
+	}
+
+	if journal.Completed {
+		fmt.Printf("'%v' is already imported\n", dir)
+		return errSkipped
+	}
+
+	if exists && !opts.resume {
+		return fmt.Errorf("Found unfinished import (%v), use --resume to continue it", journal.path)
+	}
+
+	workDir := path.Join(stateDir, "work")
+	err = os.MkdirAll(workDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	plan, err := buildPlan(ctx, api, dir, workDir, opts.covers.names)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Importing '%v' - '%v'\n", plan.Album.Artist, plan.Album.Name)
+	fmt.Printf("Work Dir: %v\n", workDir)
+
+	err = prepareCovers(ctx, plan, opts.covers.normalize)
+	if err != nil {
+		return err
+	}
+
+	err = journal.Save()
+	if err != nil {
+		return err
+	}
+
+	err = executePlan(ctx, api, plan, journal)
+	if err != nil {
+		return err
+	}
+
+	existing, err := existingTracks(ctx, api, journal.AlbumId)
+	if err != nil {
+		return err
+	}
+
+	var pending []PlanTrack
+	for _, track := range plan.Tracks {
+		entry := journal.Track(track.Disc, track.Number)
+		if entry.Uploaded {
+			fmt.Printf("Skipping track %v-%v - %v (already imported)\n", track.Disc, track.Number, track.Name)
+			continue
+		}
+
+		// NOTE(patrik): The journal can be missing or behind the server,
+		// e.g. when it was deleted or the upload finished right before
+		// the importer was stopped
+		if trackId, found := existing[track.AlbumNumber]; found {
+			err = journal.UpdateTrack(track.Disc, track.Number, func(entry *JournalTrack) {
+				entry.Uploaded = true
+				entry.TrackId = trackId
+			})
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("Skipping track %v-%v - %v (already on the server)\n", track.Disc, track.Number, track.Name)
+			continue
+		}
+
+		pending = append(pending, track)
+	}
+
+	trackErrs, err := transcodeTracks(ctx, pending, journal, opts.jobs, transcodeTrack)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+
+	for i, track := range pending {
+		if trackErrs[i] != nil {
+			errs = append(errs, trackErrs[i])
+			continue
+		}
+
+		artistId, extraArtistIds := journal.ArtistIds(track.Artists)
+
+		trackId, err := uploadTrack(ctx, api, ProcessedTrack{
+			Name:              track.Name,
+			Number:            track.AlbumNumber,
+			Disc:              track.Disc,
+			AlbumId:           journal.AlbumId,
+			ArtistId:          artistId,
+			ExtraArtistIds:    extraArtistIds,
+			RecordingMbid:     track.RecordingMbid,
+			Duration:          track.Duration,
+			BestQualityFile:   track.BestQualityFile,
+			MobileQualityFile: track.MobileQualityFile,
+			CoverArt:          track.CoverArt,
+		})
+		if err != nil {
+			if !errors.Is(err, server.ErrConflict) {
+				return fmt.Errorf("Failed to upload track '%v': %w", track.Name, err)
+			}
+
+			fmt.Printf("Track %v-%v - %v already exists on the server\n", track.Disc, track.Number, track.Name)
+		}
+
+		err = journal.UpdateTrack(track.Disc, track.Number, func(entry *JournalTrack) {
+			entry.Uploaded = true
+			entry.TrackId = trackId
+		})
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Imported track %v-%v - %v\n", track.Disc, track.Number, track.Name)
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	journal.Completed = true
+	err = journal.Save()
+	if err != nil {
+		return err
+	}
+
+	// NOTE(patrik): The journal is kept so a rerun is a no-op, the
+	// transcoded files are not needed anymore
+	return os.RemoveAll(workDir)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+)
+
+type JournalTrack struct {
+	BestQualityDone   bool   `json:"bestQualityDone"`
+	MobileQualityDone bool   `json:"mobileQualityDone"`
+	Uploaded          bool   `json:"uploaded"`
+	TrackId           string `json:"trackId,omitempty"`
+}
+
+// Journal records the progress of an album import so an interrupted
+// import can be resumed without creating anything twice on the server
+type Journal struct {
+	path string
+	mu   sync.Mutex
+
+	Dir       string                   `json:"dir"`
+	Artists   map[string]string        `json:"artists"`
+	AlbumId   string                   `json:"albumId,omitempty"`
+	Tracks    map[string]*JournalTrack `json:"tracks"`
+	Completed bool                     `json:"completed"`
+}
+
+func defaultStateDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	return path.Join(dir, "dwebble-import"), nil
+}
+
+// albumStateDir returns the directory inside stateDir used for the
+// journal and the transcoded files of the album in dir
+func albumStateDir(stateDir, dir string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha1.Sum([]byte(abs))
+	return path.Join(stateDir, hex.EncodeToString(hash[:8])), nil
+}
+
+func journalTrackKey(disc, number int) string {
+	return fmt.Sprintf("%v-%v", disc, number)
+}
+
+// openJournal reads the journal from albumStateDir, if no journal exists
+// a new empty one is returned
+func openJournal(albumStateDir, dir string) (*Journal, bool, error) {
+	p := path.Join(albumStateDir, "journal.json")
+
+	journal := &Journal{
+		path:    p,
+		Dir:     dir,
+		Artists: make(map[string]string),
+		Tracks:  make(map[string]*JournalTrack),
+	}
+
+	data, err := os.ReadFile(p)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return journal, false, nil
+		}
+
+		return nil, false, err
+	}
+
+	err = json.Unmarshal(data, journal)
+	if err != nil {
+		return nil, false, fmt.Errorf("%v: %w", p, err)
+	}
+
+	if journal.Artists == nil {
+		journal.Artists = make(map[string]string)
+	}
+
+	if journal.Tracks == nil {
+		journal.Tracks = make(map[string]*JournalTrack)
+	}
+
+	return journal, true, nil
+}
+
+func (journal *Journal) track(disc, number int) *JournalTrack {
+	key := journalTrackKey(disc, number)
+
+	track, exists := journal.Tracks[key]
+	if !exists {
+		track = &JournalTrack{}
+		journal.Tracks[key] = track
+	}
+
+	return track
+}
+
+// Track returns a copy of the recorded state of a track
+func (journal *Journal) Track(disc, number int) JournalTrack {
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+
+	return *journal.track(disc, number)
+}
+
+// UpdateTrack runs fn on the recorded state of a track and saves the
+// journal, it's safe to call from multiple goroutines
+func (journal *Journal) UpdateTrack(disc, number int, fn func(track *JournalTrack)) error {
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+
+	fn(journal.track(disc, number))
+	return journal.save()
+}
+
+// ArtistIds maps the artist names to the ids of the created artists, the
+// first name is the primary artist and the rest are returned as extra
+// artists
+func (journal *Journal) ArtistIds(names []string) (string, []string) {
+	if len(names) == 0 {
+		return "", nil
+	}
+
+	var extra []string
+	for _, name := range names[1:] {
+		if id := journal.Artists[name]; id != "" && name != names[0] {
+			extra = append(extra, id)
+		}
+	}
+
+	return journal.Artists[names[0]], extra
+}
+
+// Save writes the journal to disk, the file is replaced atomically so a
+// crash while saving can't leave a half written journal behind
+func (journal *Journal) Save() error {
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+
+	return journal.save()
+}
+
+func (journal *Journal) save() error {
+	err := os.MkdirAll(path.Dir(journal.path), 0755)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(journal, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := journal.path + ".tmp"
+	err = os.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, journal.path)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/nanoteck137/dwebble-importer/utils"
+)
+
+var errSkipped = errors.New("skipped")
+
+// isAlbumDir checks if dir has an album.toml or contains tracks, either
+// directly or inside disc folders (CD1, Disc 2, ...)
+func isAlbumDir(dir string) (bool, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false, err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			if _, _, ok := utils.ParseDiscDir(entry.Name()); ok {
+				return true, nil
+			}
+
+			continue
+		}
+
+		if entry.Name() == "album.toml" {
+			return true, nil
+		}
+
+		ext := path.Ext(entry.Name())
+		if ext != "" && utils.IsValidTrackExt(ext[1:]) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// findAlbumDirs walks root and returns every album directory, the walk
+// doesn't descend into albums so disc folders are not reported on their
+// own
+func findAlbumDirs(root string) ([]string, error) {
+	var dirs []string
+
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !d.IsDir() {
+			return nil
+		}
+
+		isAlbum, err := isAlbumDir(p)
+		if err != nil {
+			return err
+		}
+
+		if isAlbum {
+			dirs = append(dirs, p)
+			return filepath.SkipDir
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return dirs, nil
+}
+
+type failedDir struct {
+	dir string
+	err error
+}
+
+type summary struct {
+	succeeded []string
+	skipped   []string
+	failed    []failedDir
+}
+
+func (s *summary) add(dir string, err error) {
+	switch {
+	case err == nil:
+		s.succeeded = append(s.succeeded, dir)
+	case errors.Is(err, errSkipped):
+		s.skipped = append(s.skipped, dir)
+	default:
+		s.failed = append(s.failed, failedDir{dir: dir, err: err})
+	}
+}
+
+func (s *summary) Print() {
+	fmt.Printf("\nSummary: %v succeeded, %v skipped, %v failed\n", len(s.succeeded), len(s.skipped), len(s.failed))
+
+	for _, dir := range s.skipped {
+		fmt.Printf("  Skipped: %v\n", dir)
+	}
+
+	for _, f := range s.failed {
+		fmt.Printf("  Failed: %v: %v\n", f.dir, f.err)
+	}
+}
+
+// runForDirs runs fn for every dir in dirs, when recursive is set every
+// dir is treated as a library root and fn is called for all the albums
+// found inside it
+func runForDirs(dirs []string, recursive bool, fn func(dir string) error) (*summary, error) {
+	var albumDirs []string
+
+	if recursive {
+		for _, root := range dirs {
+			res, err := findAlbumDirs(root)
+			if err != nil {
+				return nil, err
+			}
+
+			albumDirs = append(albumDirs, res...)
+		}
+	} else {
+		albumDirs = dirs
+	}
+
+	s := &summary{}
+
+	for _, dir := range albumDirs {
+		err := fn(dir)
+		if err != nil && !errors.Is(err, errSkipped) {
+			fmt.Fprintf(os.Stderr, "Error: %v: %v\n", dir, err)
+		}
+
+		s.add(dir, err)
+	}
+
+	return s, nil
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nanoteck137/dwebble-importer/discid"
+	"github.com/nanoteck137/dwebble-importer/musicbrainz"
+)
+
+// NOTE(patrik): Only the best search results are fetched in full since
+// every release needs its own request
+const maxLookupCandidates = 5
+
+type releaseCandidate struct {
+	search     musicbrainz.SearchRelease
+	metadata   musicbrainz.Metadata
+	confidence float64
+}
+
+func formatLength(seconds float64) string {
+	s := int(math.Round(seconds))
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
+
+// durationScore compares the local track durations with the track
+// lengths of the release, 1 means every track is within a second
+func durationScore(config *Config, durations map[string]float64, metadata *musicbrainz.Metadata) (float64, bool) {
+	total := 0.0
+	count := 0
+
+	for _, track := range config.Tracks {
+		local := durations[track.Filename]
+		if local <= 0 {
+			continue
+		}
+
+		releaseTrack, found := findReleaseTrack(metadata, track.DiscNumber(), track.Num)
+		if !found || releaseTrack.Length <= 0 {
+			continue
+		}
+
+		diff := math.Abs(local - float64(releaseTrack.Length)/1000.0)
+		total += math.Max(0, 1-math.Max(0, diff-1)/10)
+		count++
+	}
+
+	if count == 0 {
+		return 0, false
+	}
+
+	return total / float64(count), true
+}
+
+// scoreCandidate returns a confidence between 0 and 1 based on the
+// search score, the track count and the track durations
+func scoreCandidate(config *Config, durations map[string]float64, candidate *releaseCandidate) float64 {
+	searchScore := float64(candidate.search.Score) / 100
+
+	releaseTrackCount := 0
+	for _, media := range candidate.metadata.Media {
+		releaseTrackCount += len(media.Tracks)
+	}
+
+	trackScore := 0.0
+	if releaseTrackCount == len(config.Tracks) {
+		trackScore = 1
+	}
+
+	score, ok := durationScore(config, durations, &candidate.metadata)
+	if !ok {
+		return 0.5*searchScore + 0.5*trackScore
+	}
+
+	return 0.3*searchScore + 0.3*trackScore + 0.4*score
+}
+
+func printCandidate(index int, candidate *releaseCandidate) {
+	metadata := &candidate.metadata
+
+	artist := metadata.ArtistCredit.String()
+
+	var formats []string
+	for _, media := range metadata.Media {
+		formats = append(formats, media.Format)
+	}
+
+	fmt.Printf("%v) [%.2f] %v - %v\n", index, candidate.confidence, artist, metadata.Title)
+	fmt.Printf("   Date: %v, Country: %v, Format: %v\n", metadata.Date, candidate.search.Country, strings.Join(formats, " + "))
+	fmt.Printf("   Id: %v\n", metadata.Id)
+
+	for _, media := range metadata.Media {
+		for _, track := range media.Tracks {
+			fmt.Printf("     %v-%v %v (%v)\n", media.Position, track.Position, track.Title, formatLength(float64(track.Length)/1000))
+		}
+	}
+}
+
+func pickCandidate(count int) (int, error) {
+	reader := bufio.NewReader(os.Stdin)
+
+	for {
+		fmt.Printf("Pick release (1-%v, 0 to skip): ", count)
+		text, err := reader.ReadString('\n')
+		if err != nil {
+			return 0, err
+		}
+
+		num, err := strconv.Atoi(strings.TrimSpace(text))
+		if err != nil || num < 0 || num > count {
+			fmt.Printf("Invalid choice\n")
+			continue
+		}
+
+		return num, nil
+	}
+}
+
+func searchCandidates(mb *musicbrainz.Client, config *Config) ([]releaseCandidate, error) {
+	if config.Name == "" {
+		return nil, errors.New("Can't lookup release without an album name")
+	}
+
+	results, err := mb.SearchReleases(config.Name, config.Artist, len(config.Tracks))
+	if err != nil {
+		return nil, err
+	}
+
+	// NOTE(patrik): Retry without the track count, the local files might
+	// be missing tracks or have extra ones
+	if len(results) == 0 {
+		results, err = mb.SearchReleases(config.Name, config.Artist, 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(results) == 0 {
+		return nil, fmt.Errorf("No releases found for '%v' - '%v'", config.Artist, config.Name)
+	}
+
+	if len(results) > maxLookupCandidates {
+		results = results[:maxLookupCandidates]
+	}
+
+	var candidates []releaseCandidate
+
+	for _, result := range results {
+		metadata, err := mb.FetchAlbumMetadata(result.Id)
+		if err != nil {
+			return nil, err
+		}
+
+		candidates = append(candidates, releaseCandidate{
+			search:   result,
+			metadata: metadata,
+		})
+	}
+
+	return candidates, nil
+}
+
+// discIdCandidates returns the releases matching the disc id of toc, an
+// exact disc id match gets the full search score while releases found by
+// the fuzzy TOC lookup get a bit less
+func discIdCandidates(mb *musicbrainz.Client, toc *discid.TOC) ([]releaseCandidate, error) {
+	discId := toc.MusicBrainzId()
+
+	releases, err := mb.LookupDiscId(discId, toc.String())
+	if errors.Is(err, musicbrainz.ErrNotFound) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []releaseCandidate
+	for _, release := range releases {
+		score := 90
+		for i := range release.Media {
+			if release.Media[i].HasDisc(discId) {
+				score = 100
+				break
+			}
+		}
+
+		candidates = append(candidates, releaseCandidate{
+			search: musicbrainz.SearchRelease{
+				Id:      release.Id,
+				Score:   score,
+				Title:   release.Title,
+				Date:    release.Date,
+				Country: release.Country,
+			},
+			metadata: release,
+		})
+	}
+
+	return candidates, nil
+}
+
+// lookupRelease finds the MusicBrainz release matching config, by the
+// disc id when toc is set and otherwise by searching for the album name.
+// The best candidate is used directly when its confidence is at least
+// autoAccept, otherwise the user picks one if prompt is set. Returns nil
+// when no release was picked
+func lookupRelease(mb *musicbrainz.Client, config *Config, durations map[string]float64, toc *discid.TOC, prompt bool, autoAccept float64) (*musicbrainz.Metadata, error) {
+	var candidates []releaseCandidate
+
+	if toc != nil {
+		var err error
+		candidates, err = discIdCandidates(mb, toc)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(candidates) == 0 {
+			fmt.Printf("No releases found for disc id '%v', searching by name\n", toc.MusicBrainzId())
+		}
+	}
+
+	if len(candidates) == 0 {
+		var err error
+		candidates, err = searchCandidates(mb, config)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range candidates {
+		candidates[i].confidence = scoreCandidate(config, durations, &candidates[i])
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].confidence > candidates[j].confidence
+	})
+
+	best := &candidates[0]
+	if autoAccept > 0 && best.confidence >= autoAccept {
+		fmt.Printf("Auto accepting release '%v' (%.2f)\n", best.metadata.Id, best.confidence)
+		return &best.metadata, nil
+	}
+
+	if !prompt {
+		return nil, fmt.Errorf("No release above the confidence threshold (best was %.2f)", best.confidence)
+	}
+
+	for i := range candidates {
+		printCandidate(i+1, &candidates[i])
+	}
+
+	choice, err := pickCandidate(len(candidates))
+	if err != nil {
+		return nil, err
+	}
+
+	if choice == 0 {
+		return nil, nil
+	}
+
+	return &candidates[choice-1].metadata, nil
+}
@@ -1,38 +1,34 @@
 package main
 
 import (
-	"bufio"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"path"
-	"sort"
-	"strings"
+	"runtime"
+	"time"
 
-	"github.com/kr/pretty"
+	"github.com/nanoteck137/dwebble-importer/acoustid"
+	"github.com/nanoteck137/dwebble-importer/coverart"
+	"github.com/nanoteck137/dwebble-importer/musicbrainz"
 	"github.com/nanoteck137/dwebble-importer/server"
 	"github.com/nanoteck137/dwebble-importer/utils"
-	"github.com/pelletier/go-toml/v2"
 	"github.com/spf13/cobra"
 )
 
-type UnprocessedTrack struct {
-	Name      string
-	Number    int
-	AlbumId   string
-	ArtistId  string
-	TrackFile string
-	// BestQualityFile   string
-	// MobileQualityFile string
-	// CoverArt          string
-}
-
 type ProcessedTrack struct {
 	Name              string
 	Number            int
+	Disc              int
 	AlbumId           string
 	ArtistId          string
+	ExtraArtistIds    []string
+	RecordingMbid     string
+	Duration          float64
 	BestQualityFile   string
 	MobileQualityFile string
 	CoverArt          string
@@ -52,630 +48,489 @@ type Track struct {
 
 type ConfigTrack struct {
 	Num      int    `toml:"num"`
+	Disc     int    `toml:"disc"`
 	Name     string `toml:"name"`
 	Filename string `toml:"filename"`
 	Artist   string `toml:"artist"`
+
+	// NOTE(patrik): Every credited artist, Artist is the full credit
+	// (e.g. "A feat. B") used for display
+	Artists []string `toml:"artists,omitempty"`
+
+	RecordingMbid string   `toml:"recording_mbid,omitempty"`
+	ArtistMbids   []string `toml:"artist_mbids,omitempty"`
 }
 
 type Config struct {
 	Typ    string `toml:"type"`
 	Name   string `toml:"name"`
 	Artist string `toml:"artist"`
+	Date   string `toml:"date,omitempty"`
+
+	Artists []string `toml:"artists,omitempty"`
+
+	// NOTE(patrik): ArtistMbids lines up with the album artists, the
+	// same goes for the tracks
+	ReleaseMbid      string   `toml:"release_mbid,omitempty"`
+	ReleaseGroupMbid string   `toml:"release_group_mbid,omitempty"`
+	ArtistMbids      []string `toml:"artist_mbids,omitempty"`
 
+	Discs  []ConfigDisc  `toml:"discs,omitempty"`
 	Tracks []ConfigTrack `toml:"tracks"`
 }
 
+type ConfigDisc struct {
+	Num      int    `toml:"num"`
+	Subtitle string `toml:"subtitle"`
+}
+
+// DiscNumber returns the disc of the track, tracks without a disc are
+// treated as being on the first disc
+func (track *ConfigTrack) DiscNumber() int {
+	if track.Disc <= 0 {
+		return 1
+	}
+
+	return track.Disc
+}
+
+// AlbumTrackNumber returns the number of the track counted over the
+// whole album, the tracks of the earlier discs come first.
+//
+// NOTE(patrik): The server doesn't know about discs and needs the
+// number to be unique within the album, so the disc is only sent along
+// as an extra field. The highest number on a disc is used as its track
+// count so a disc with missing tracks can't overlap with the next one
+func (config *Config) AlbumTrackNumber(track *ConfigTrack) int {
+	disc := track.DiscNumber()
+
+	counts := make(map[int]int)
+	for i := range config.Tracks {
+		d := config.Tracks[i].DiscNumber()
+		if d < disc && config.Tracks[i].Num > counts[d] {
+			counts[d] = config.Tracks[i].Num
+		}
+	}
+
+	number := track.Num
+	for _, count := range counts {
+		number += count
+	}
+
+	return number
+}
+
+// AlbumArtists returns the names of every artist credited on the album,
+// the first one is the primary artist
+func (config *Config) AlbumArtists() []string {
+	if len(config.Artists) > 0 {
+		return config.Artists
+	}
+
+	if config.Artist == "" {
+		return nil
+	}
+
+	return []string{config.Artist}
+}
+
+// TrackArtists returns the names of every artist credited on the track,
+// tracks without their own artist use the album artists
+func (config *Config) TrackArtists(track *ConfigTrack) []string {
+	if len(track.Artists) > 0 {
+		return track.Artists
+	}
+
+	if track.Artist != "" {
+		return []string{track.Artist}
+	}
+
+	return config.AlbumArtists()
+}
+
+// ArtistMbidMap maps the artist names to their MusicBrainz ids, artists
+// without a known id are left out
+func (config *Config) ArtistMbidMap() map[string]string {
+	mbids := make(map[string]string)
+
+	add := func(names, ids []string) {
+		for i, name := range names {
+			if i < len(ids) && ids[i] != "" {
+				mbids[name] = ids[i]
+			}
+		}
+	}
+
+	add(config.AlbumArtists(), config.ArtistMbids)
+	for i := range config.Tracks {
+		track := &config.Tracks[i]
+		if len(track.ArtistMbids) > 0 {
+			add(config.TrackArtists(track), track.ArtistMbids)
+		}
+	}
+
+	return mbids
+}
+
+func (config *Config) DiscSubtitle(disc int) string {
+	for _, d := range config.Discs {
+		if d.Num == disc {
+			return d.Subtitle
+		}
+	}
+
+	return ""
+}
+
 var rootCmd = &cobra.Command{
 	Use:     "dwebble-import",
 	Version: "v0.0.1",
 	CompletionOptions: cobra.CompletionOptions{
 		DisableDefaultCmd: true,
 	},
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		name, _ := cmd.Flags().GetString("prober")
+
+		prober, err := utils.NewProber(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		utils.DefaultProber = prober
+	},
 	Run: func(cmd *cobra.Command, args []string) {
 		fmt.Println("Run")
 	},
 }
 
 var createConfigCmd = &cobra.Command{
-	Use:   "create-config",
+	Use:   "create-config [dir]",
 	Short: "Create new album config",
 	Args:  cobra.RangeArgs(0, 1),
 	Run: func(cmd *cobra.Command, args []string) {
+		recursive, _ := cmd.Flags().GetBool("recursive")
+		mbid, _ := cmd.Flags().GetString("mbid")
+		lookup, _ := cmd.Flags().GetBool("lookup")
+		autoAccept, _ := cmd.Flags().GetFloat64("auto-accept")
+		tolerance, _ := cmd.Flags().GetDuration("duration-tolerance")
+
+		if recursive && mbid != "" {
+			log.Fatal("--mbid can't be used together with --recursive")
+		}
+
+		if mbid != "" && lookup {
+			log.Fatal("--mbid can't be used together with --lookup")
+		}
+
+		if recursive && lookup && autoAccept <= 0 {
+			log.Fatal("--lookup together with --recursive needs --auto-accept")
+		}
+
+		mb, err := newMusicBrainzClient(cmd)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		fingerprint, err := newFingerprintOptions(cmd)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		templates, _ := cmd.Flags().GetStringArray("pattern")
+		if len(templates) == 0 {
+			templates = utils.DefaultFilenamePatterns
+		}
+
+		patterns, err := utils.ParseFilenamePatterns(templates)
+		if err != nil {
+			log.Fatal(err)
+		}
+
 		dir := "./"
 		if len(args) > 0 {
 			dir = args[0]
 		}
-		runCreateConfig(dir)
+
+		if !recursive {
+			err := runCreateConfig(dir, createConfigOptions{
+				prompt:      true,
+				mbid:        mbid,
+				lookup:      lookup,
+				autoAccept:  autoAccept,
+				tolerance:   tolerance.Seconds(),
+				patterns:    patterns,
+				fingerprint: fingerprint,
+				mb:          mb,
+			})
+			if err != nil && !errors.Is(err, errSkipped) {
+				log.Fatal(err)
+			}
+
+			return
+		}
+
+		s, err := runForDirs([]string{dir}, true, func(dir string) error {
+			fmt.Printf("Creating config for '%v'\n", dir)
+			return runCreateConfig(dir, createConfigOptions{
+				prompt:      false,
+				lookup:      lookup,
+				autoAccept:  autoAccept,
+				tolerance:   tolerance.Seconds(),
+				patterns:    patterns,
+				fingerprint: fingerprint,
+				mb:          mb,
+			})
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		s.Print()
+		if len(s.failed) > 0 {
+			os.Exit(1)
+		}
 	},
 }
 
 var importCmd = &cobra.Command{
-	Use:   "import",
+	Use:   "import [dir...]",
 	Short: "Import album to dwebble server",
 	Run: func(cmd *cobra.Command, args []string) {
 		serverAddr, _ := cmd.Flags().GetString("serverAddr")
-		fmt.Printf("Import %v\n", serverAddr)
-	},
-}
-
-func init() {
-	importCmd.PersistentFlags().StringP("serverAddr", "s", "", "")
-
-	rootCmd.AddCommand(createConfigCmd)
-	rootCmd.AddCommand(importCmd)
-}
-
-func runCreateConfig(dir string) {
-	fmt.Printf("Dir: %v\n", dir)
-
-	entries, err := os.ReadDir(dir)
-	if err != nil {
-		log.Fatal(err)
-	}
+		recursive, _ := cmd.Flags().GetBool("recursive")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		jsonOutput, _ := cmd.Flags().GetBool("json")
+		verify, _ := cmd.Flags().GetBool("verify")
+		tolerance, _ := cmd.Flags().GetDuration("duration-tolerance")
+
+		if jsonOutput && !dryRun {
+			log.Fatal("--json can only be used together with --dry-run")
+		}
 
-	var fileResults []utils.FileResult
+		opts := importOptions{}
+		opts.resume, _ = cmd.Flags().GetBool("resume")
+		opts.stateDir, _ = cmd.Flags().GetString("state-dir")
+		opts.jobs, _ = cmd.Flags().GetInt("jobs")
+		opts.covers.names, _ = cmd.Flags().GetStringSlice("cover-names")
+		opts.covers.normalize.MaxEdge, _ = cmd.Flags().GetInt("cover-max-edge")
+		opts.covers.normalize.MaxBytes, _ = cmd.Flags().GetInt("cover-max-bytes")
 
-	for _, entry := range entries {
-		p := path.Join(dir, entry.Name())
+		if opts.jobs <= 0 {
+			log.Fatal("--jobs needs to be at least 1")
+		}
 
-		ext := path.Ext(p)[1:]
-		if utils.IsValidTrackExt(ext) {
-			res, err := utils.CheckFile(p)
+		if opts.stateDir == "" {
+			stateDir, err := defaultStateDir()
 			if err != nil {
 				log.Fatal(err)
 			}
 
-			fileResults = append(fileResults, res)
+			opts.stateDir = stateDir
 		}
-	}
-
-	albumArtistName := ""
-	albumName := ""
-	var tracks []ConfigTrack
 
-	for _, file := range fileResults {
-		if file.Probe.Track != -1 && file.Probe.Track != file.Number {
-			log.Fatal("Track number not matching")
+		dirs := args
+		if len(dirs) == 0 {
+			dirs = []string{"./"}
 		}
 
-		if file.Probe.AlbumArtist != "" {
-			albumArtistName = file.Probe.AlbumArtist
-		}
+		timeout, _ := cmd.Flags().GetDuration("timeout")
 
-		if file.Probe.Album != "" {
-			albumName = file.Probe.Album
+		creds, err := loadCredentials(cmd)
+		if err != nil {
+			log.Fatal(err)
 		}
 
-		tracks = append(tracks, ConfigTrack{
-			Num:      file.Number,
-			Name:     file.Probe.Title,
-			Filename: path.Base(file.Path),
-			Artist:   file.Probe.Artist,
-		})
-	}
-
-	sort.SliceStable(tracks, func(i, j int) bool {
-		return tracks[i].Num < tracks[j].Num
-	})
-
-	config := Config{
-		Typ:    "",
-		Name:   albumName,
-		Artist: albumArtistName,
-		Tracks: tracks,
-	}
-
-	data, err := toml.Marshal(config)
-	if err != nil {
-		log.Fatal(err)
-	}
+		options := append([]server.Option{server.WithTimeout(timeout)}, creds.Options()...)
+		api := server.New(serverAddr, options...)
 
-	fmt.Print(string(data))
-
-	configPath := path.Join(dir, "album.toml")
-	if _, err := os.Stat(configPath); !errors.Is(err, os.ErrNotExist) {
-		reader := bufio.NewReader(os.Stdin)
-		fmt.Print("Config already exists overwrite (y/n): ")
-		text, _ := reader.ReadString('\n')
-		text = strings.TrimSpace(text)
-
-		switch text {
-		case "y", "yes":
-			fmt.Printf("Writing config\n")
-			os.WriteFile(configPath, data, 0644)
-		default:
-			fmt.Printf("Not writing config\n")
+		mb, err := newMusicBrainzClient(cmd)
+		if err != nil {
+			log.Fatal(err)
 		}
-	} else {
-		os.WriteFile(configPath, data, 0644)
-	}
-}
 
-func main() {
-	if err := rootCmd.Execute(); err != nil {
-		log.Fatal(err)
-	}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
 
-	return
-	api := server.New("http://localhost:3000/api/v1")
+		var plans []*Plan
 
-	// d := "/Volumes/media/musicraw/Metallica/Metallica"
-	d := "/Volumes/media/music/Ado/unravel"
+		s, err := runForDirs(dirs, recursive, func(dir string) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
 
-	p := path.Join(d, "album.toml")
-	data, err := os.ReadFile(p)
-	if err != nil {
-		log.Fatal(err)
-	}
+			if recursive {
+				_, err := os.Stat(path.Join(dir, "album.toml"))
+				if errors.Is(err, os.ErrNotExist) {
+					return errSkipped
+				}
+			}
 
-	var config Config
-	err = toml.Unmarshal(data, &config)
-	if err != nil {
-		log.Fatal(err)
-	}
+			var mismatches []DurationMismatch
+			if verify {
+				config, err := readConfig(dir)
+				if err != nil {
+					return err
+				}
+
+				mismatches, err = verifyDurations(mb, dir, &config, tolerance.Seconds())
+				if err != nil {
+					return err
+				}
+			}
 
-	pretty.Println(config)
+			if dryRun {
+				plan, err := buildPlan(ctx, api, dir, "<work-dir>", opts.covers.names)
+				if err != nil {
+					return err
+				}
 
-	allArtists := make(map[string]string)
+				plan.DurationMismatches = mismatches
 
-	allArtists[config.Name] = ""
+				if !jsonOutput {
+					plan.Print()
+				}
 
-	for _, track := range config.Tracks {
-		if track.Artist != "" {
-			allArtists[track.Artist] = ""
-		}
-	}
+				plans = append(plans, plan)
+				return nil
+			}
+
+			if len(mismatches) > 0 {
+				printDurationMismatches(mismatches)
+				return fmt.Errorf("%v track(s) don't match the MusicBrainz release, use --verify=false to import anyway", len(mismatches))
+			}
 
-	for name := range allArtists {
-		res, err := api.GetArtists(name)
+			return runImport(ctx, api, dir, opts)
+		})
 		if err != nil {
 			log.Fatal(err)
 		}
 
-		if len(res.Artists) == 0 {
-			artist, err := api.CreateArtist(server.ArtistData{
-				Name:    name,
-				Picture: nil,
-			})
+		if jsonOutput {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetEscapeHTML(false)
+			encoder.SetIndent("", "  ")
 
+			err := encoder.Encode(plans)
 			if err != nil {
 				log.Fatal(err)
 			}
-
-			allArtists[name] = artist.Id
 		} else {
-			if len(res.Artists) > 1 {
-				log.Fatalf("Server returned more then one artist for name '%s'", name)
-			}
-
-			allArtists[name] = res.Artists[0].Id
-		}
-	}
-
-	artistId := allArtists[config.Artist]
-	albums, err := api.GetArtistAlbums(artistId, config.Name)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	albumId := ""
-
-	if len(albums.Albums) == 0 {
-		album, err := api.CreateAlbum(server.AlbumData{
-			Name:     config.Name,
-			ArtistId: artistId,
-			CoverArt: nil,
-		})
-
-		if err != nil {
-			log.Fatal(err)
+			s.Print()
 		}
-
-		pretty.Println(album)
-		albumId = album.Id
-	} else {
-		if len(albums.Albums) > 1 {
-			log.Fatalf("Server returned more then one album for '%v' - '%v'", config.Artist, config.Name)
+		if len(s.failed) > 0 {
+			os.Exit(1)
 		}
+	},
+}
 
-		albumId = albums.Albums[0].Id
+func init() {
+	createConfigCmd.Flags().BoolP("recursive", "r", false, "Create configs for every album found under dir")
+	createConfigCmd.Flags().String("mbid", "", "MusicBrainz release id used to fill in the config")
+	createConfigCmd.Flags().Bool("lookup", false, "Look up the release on MusicBrainz by the disc id of a rip log or cue sheet, or else by the file tags")
+	createConfigCmd.Flags().Float64("auto-accept", 0, "Use the best lookup match without asking if its confidence (0-1) is at least this")
+	createConfigCmd.Flags().StringArray("pattern", nil, "Filename pattern like '{disc}-{track} - {artist} - {title}', can be given more then once and replaces the default patterns")
+	createConfigCmd.Flags().Bool("fingerprint", false, "Identify tracks without a title by their AcoustID fingerprint")
+	createConfigCmd.Flags().String("fingerprinter", acoustid.FingerprinterAuto, "Program used for fingerprints (auto, fpcalc or ffmpeg)")
+	createConfigCmd.Flags().String("acoustid-key", "", "AcoustID application key (env ACOUSTID_KEY)")
+	createConfigCmd.Flags().String("acoustid-url", acoustid.DefaultUrl, "AcoustID web service url")
+
+	importCmd.PersistentFlags().StringP("serverAddr", "s", "http://localhost:3000/api/v1", "Dwebble server address")
+	importCmd.PersistentFlags().String("token", "", "API token for the server (env DWEBBLE_TOKEN)")
+	importCmd.PersistentFlags().String("username", "", "Username used to sign in to the server (env DWEBBLE_USERNAME)")
+	importCmd.PersistentFlags().String("password", "", "Password used to sign in to the server (env DWEBBLE_PASSWORD)")
+	importCmd.PersistentFlags().String("credentials", "", "Credentials file (default is dwebble-import/credentials.toml in the user config dir)")
+	importCmd.PersistentFlags().Duration("timeout", 5*time.Minute, "Timeout for a single request to the server")
+	importCmd.Flags().BoolP("recursive", "r", false, "Import every album found under the given dirs")
+	importCmd.Flags().Bool("dry-run", false, "Print the import plan without changing anything on the server")
+	importCmd.Flags().Bool("json", false, "Print the dry-run plan as JSON")
+	importCmd.Flags().Bool("resume", false, "Resume unfinished imports from their journal")
+	importCmd.Flags().IntP("jobs", "j", runtime.NumCPU(), "Number of tracks to transcode in parallel")
+	importCmd.Flags().String("state-dir", "", "Directory for import journals and transcoded files (default is the user cache dir)")
+	importCmd.Flags().Bool("verify", true, "Compare track durations with the MusicBrainz release before uploading")
+	importCmd.Flags().StringSlice("cover-names", coverart.DefaultNames, "Cover images to look for in the album dir in order of priority, relative to the dir and without extension")
+	importCmd.Flags().Int("cover-max-edge", coverart.DefaultOptions.MaxEdge, "Covers larger then this many pixels are downscaled, 0 disables")
+	importCmd.Flags().Int("cover-max-bytes", coverart.DefaultOptions.MaxBytes, "Covers are recompressed to fit in this many bytes, 0 disables")
+
+	for _, cmd := range []*cobra.Command{createConfigCmd, importCmd} {
+		addMusicBrainzFlags(cmd)
+		cmd.Flags().Duration("duration-tolerance", 3*time.Second, "Max difference between a file and the MusicBrainz track length")
 	}
 
-	var unprocessedTracks []UnprocessedTrack
+	rootCmd.PersistentFlags().String("prober", utils.ProberFFprobe, "How the tags and audio info of files are read, ffprobe or native (no external programs, only flac, mp3, mp4 and wav)")
 
-	for _, track := range config.Tracks {
-		artist := config.Artist
-		if track.Artist != "" {
-			artist = track.Artist
-		}
-
-		trackFile := path.Join(d, track.Filename)
+	rootCmd.AddCommand(createConfigCmd)
+	rootCmd.AddCommand(importCmd)
+}
 
-		unprocessedTracks = append(unprocessedTracks, UnprocessedTrack{
-			Name:      track.Name,
-			Number:    track.Num,
-			AlbumId:   albumId,
-			ArtistId:  allArtists[artist],
-			TrackFile: trackFile,
-		})
+func newFingerprintOptions(cmd *cobra.Command) (*fingerprintOptions, error) {
+	enabled, _ := cmd.Flags().GetBool("fingerprint")
+	if !enabled {
+		return nil, nil
 	}
 
-	pretty.Println(unprocessedTracks)
+	fingerprinter, _ := cmd.Flags().GetString("fingerprinter")
+	apiKey, _ := cmd.Flags().GetString("acoustid-key")
+	baseUrl, _ := cmd.Flags().GetString("acoustid-url")
 
-	dir := "./testing"
-	err = os.MkdirAll(dir, 0766)
+	fingerprinter, err := acoustid.ResolveFingerprinter(fingerprinter)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	fmt.Printf("Dir: %v\n", dir)
-
-	var processedTracks []ProcessedTrack
-
-	for _, track := range unprocessedTracks {
-		// TODO(patrik): Check extention
-		dstName := fmt.Sprintf("%v.best.flac", track.Number)
-		bestQualityFilePath := path.Join(dir, dstName)
-		utils.RunFFmpeg(true, "-i", track.TrackFile, "-map_metadata", "-1", "-map", "0", "-map", "-0:v", "-c:a", "copy", bestQualityFilePath)
-
-		// ffmpeg -i input.flac -ab 320k -map_metadata 0 -id3v2_version 3 output.mp3
-		dstName = fmt.Sprintf("%v.mobile.mp3", track.Number)
-		mobileQualityFile := path.Join(dir, dstName)
-		utils.RunFFmpeg(true, "-i", track.TrackFile, "-ab", "192k", mobileQualityFile)
-
-		processedTracks = append(processedTracks, ProcessedTrack{
-			Name:              track.Name,
-			Number:            track.Number,
-			AlbumId:           track.AlbumId,
-			ArtistId:          track.ArtistId,
-			BestQualityFile:   bestQualityFilePath,
-			MobileQualityFile: mobileQualityFile,
-			CoverArt:          "./testing/test.png",
-		})
+	if apiKey == "" {
+		apiKey = os.Getenv("ACOUSTID_KEY")
 	}
 
-	getContentTypeFromExt := func(ext string) string {
-		switch ext {
-		case "flac":
-			return "audio/flac"
-		case "mp3":
-			return "audio/mpeg"
-		case "png":
-			return "image/png"
-		default:
-			log.Fatalf("Unsupported ext '%v'", ext)
-		}
-
-		return ""
+	if apiKey == "" {
+		return nil, fmt.Errorf("--fingerprint needs an AcoustID key, set --acoustid-key or ACOUSTID_KEY")
 	}
 
-	createFile := func(filePath string) (server.File, error) {
-		ext := path.Ext(filePath)[1:]
-		contentType := getContentTypeFromExt(ext)
-		name := path.Base(filePath)
+	return &fingerprintOptions{
+		fingerprinter: fingerprinter,
+		client:        acoustid.NewClient(apiKey, acoustid.WithBaseUrl(baseUrl)),
+	}, nil
+}
 
-		content, err := os.Open(filePath)
-		if err != nil {
-			return server.File{}, err
-		}
+func addMusicBrainzFlags(cmd *cobra.Command) {
+	cmd.Flags().String("mb-url", musicbrainz.DefaultMusicBrainzUrl, "MusicBrainz web service url")
+	cmd.Flags().String("cover-art-url", musicbrainz.DefaultCoverArtUrl, "Cover Art Archive url")
+	cmd.Flags().String("user-agent", musicbrainz.DefaultUserAgent, "User agent sent to MusicBrainz")
+	cmd.Flags().String("mb-cache-dir", "", "Directory for cached MusicBrainz responses (default is the user cache dir)")
+	cmd.Flags().Duration("mb-cache-ttl", 7*24*time.Hour, "How long cached MusicBrainz responses are used, 0 disables the cache")
+}
 
-		return server.File{
-			ContentType: contentType,
-			Name:        name,
-			Content:     content,
-		}, nil
+func newMusicBrainzClient(cmd *cobra.Command) (*musicbrainz.Client, error) {
+	cacheDir, _ := cmd.Flags().GetString("mb-cache-dir")
+	cacheTTL, _ := cmd.Flags().GetDuration("mb-cache-ttl")
+	musicbrainzUrl, _ := cmd.Flags().GetString("mb-url")
+	coverArtUrl, _ := cmd.Flags().GetString("cover-art-url")
+	userAgent, _ := cmd.Flags().GetString("user-agent")
+
+	options := []musicbrainz.Option{
+		musicbrainz.WithMusicBrainzUrl(musicbrainzUrl),
+		musicbrainz.WithCoverArtUrl(coverArtUrl),
+		musicbrainz.WithUserAgent(userAgent),
 	}
 
-	for _, track := range processedTracks {
-		bestQualityFile, err := createFile(track.BestQualityFile)
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		mobileQualityFile, err := createFile(track.MobileQualityFile)
-		if err != nil {
-			log.Fatal(err)
-		}
+	if cacheTTL <= 0 {
+		return musicbrainz.NewClient(options...), nil
+	}
 
-		coverArt, err := createFile(track.CoverArt)
+	if cacheDir == "" {
+		stateDir, err := defaultStateDir()
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
 
-		_, err = api.CreateTrack(server.TrackData{
-			Name:              track.Name,
-			Number:            track.Number,
-			AlbumId:           track.AlbumId,
-			ArtistId:          track.ArtistId,
-			BestQualityFile:   bestQualityFile,
-			MobileQualityFile: mobileQualityFile,
-			CoverArt:          coverArt,
-		})
-
-		if err != nil {
-			log.Println(err)
-		}
+		cacheDir = path.Join(stateDir, "musicbrainz")
 	}
 
-	pretty.Println(processedTracks)
-
-	{
-
-		// unprocessedTracks := []UnprocessedTrack{
-		// 	{
-		// 		Name:      "Enter Sandman",
-		// 		Number:    1,
-		// 		AlbumId:   album.Id,
-		// 		ArtistId:  "",
-		// 		TrackFile: "/Volumes/media/musicraw/Metallica/Metallica/track01.cdda.wav",
-		// 	},
-		// }
-		//
-		// _ = unprocessedTracks
-		//
-		// processedTracks := []ProcessedTrack{
-		// 	{
-		// 		Name:              "Test Track",
-		// 		Number:            1,
-		// 		AlbumId:           album.Id,
-		// 		ArtistId:          artistId,
-		// 		BestQualityFile:   "./testing/1.best.flac",
-		// 		MobileQualityFile: "./testing/1.mobile.mp3",
-		// 		CoverArt:          "./testing/test.png",
-		// 	},
-		// }
-		//
-		// getContentTypeFromExt := func(ext string) string {
-		// 	switch ext {
-		// 	case "flac":
-		// 		return "audio/flac"
-		// 	case "mp3":
-		// 		return "audio/mpeg"
-		// 	case "png":
-		// 		return "image/png"
-		// 	default:
-		// 		log.Fatalf("Unsupported ext '%v'", ext)
-		// 	}
-		//
-		// 	return ""
-		// }
-		//
-		// createFile := func(filePath string) (server.File, error) {
-		// 	ext := path.Ext(filePath)[1:]
-		// 	contentType := getContentTypeFromExt(ext)
-		// 	name := path.Base(filePath)
-		//
-		// 	content, err := os.Open(filePath)
-		// 	if err != nil {
-		// 		return server.File{}, err
-		// 	}
-		//
-		// 	return server.File{
-		// 		ContentType: contentType,
-		// 		Name:        name,
-		// 		Content:     content,
-		// 	}, nil
-		// }
-		//
-		// for _, track := range processedTracks {
-		// 	bestQualityFile, err := createFile(track.BestQualityFile)
-		// 	if err != nil {
-		// 		log.Fatal(err)
-		// 	}
-		//
-		// 	mobileQualityFile, err := createFile(track.MobileQualityFile)
-		// 	if err != nil {
-		// 		log.Fatal(err)
-		// 	}
-		//
-		// 	coverArt, err := createFile(track.CoverArt)
-		// 	if err != nil {
-		// 		log.Fatal(err)
-		// 	}
-		//
-		// 	track, err := api.CreateTrack(server.TrackData{
-		// 		Name:              track.Name,
-		// 		Number:            track.Number,
-		// 		AlbumId:           track.AlbumId,
-		// 		ArtistId:          track.ArtistId,
-		// 		BestQualityFile:   bestQualityFile,
-		// 		MobileQualityFile: mobileQualityFile,
-		// 		CoverArt:          coverArt,
-		// 	})
-		//
-		// 	if err != nil {
-		// 		log.Fatal(err)
-		// 	}
-		//
-		// 	pretty.Println(track)
-		// }
-	}
+	options = append(options, musicbrainz.WithCache(cacheDir, cacheTTL))
+	return musicbrainz.NewClient(options...), nil
+}
 
-	return
-
-	// artist, err := CreateArtist("test")
-	// if err != nil {
-	// 	log.Fatal(err)
-	// }
-
-	// pretty.Println(artist)
-
-	// // source := "/Volumes/media/music/Ado/unravel"
-	// // source := "/Volumes/media/musicraw/Metallica/Metallica"
-	// source := "/mnt/media/musicraw/Metallica/Metallica"
-	//
-	// // mbid := "a03d4434-6b7c-4d56-adb7-f08d478fc09f"
-	// mbid := "69a8ca83-a182-3375-a702-a30e216748c9"
-	//
-	// dirEntries, err := os.ReadDir(source)
-	// if err != nil {
-	// 	log.Fatal(err)
-	// }
-	//
-	// var validTracks []utils.FileResult
-	//
-	// for _, entry := range dirEntries {
-	// 	p := path.Join(source, entry.Name())
-	//
-	// 	ext := path.Ext(p)[1:]
-	// 	if utils.IsValidTrackExt(ext) {
-	// 		res, err := utils.CheckFile(p)
-	// 		if err != nil {
-	// 			log.Fatal(err)
-	// 		}
-	//
-	// 		validTracks = append(validTracks, res)
-	// 	}
-	//
-	// 	fmt.Printf("%v\n", p)
-	// }
-	//
-	// pretty.Println(validTracks)
-	//
-	// metadata, err := musicbrainz.FetchAlbumMetadata(mbid)
-	// if err != nil {
-	// 	log.Fatal(err)
-	// }
-	//
-	// artists := make(map[string]string)
-	//
-	// pretty.Println(metadata)
-	// metadataArtist := metadata.ArtistCredit[0]
-	// artists[metadataArtist.Name] = ""
-	//
-	// // album, err := CreateAlbum(metadata.Title, artist.Id)
-	// // if err != nil {
-	// // 	log.Fatal(err)
-	// // }
-	// //
-	// // _ = album
-	//
-	// if len(metadata.Media) > 1 {
-	// 	log.Fatal("Only support single disc media")
-	// }
-	//
-	// media := metadata.Media[0]
-	//
-	// if len(media.Tracks) != len(validTracks) {
-	// 	log.Fatal("Track length mismatch")
-	// }
-	//
-	// type UnprocessedTrack struct {
-	// 	name     string
-	// 	number   int
-	// 	filePath string
-	// 	artist   string
-	// }
-	//
-	// var unprocessedTracks []UnprocessedTrack
-	//
-	// for _, track := range validTracks {
-	// 	var metadataTrack musicbrainz.Track
-	// 	found := false
-	// 	for _, mt := range media.Tracks {
-	// 		if track.Number == mt.Position {
-	// 			metadataTrack = mt
-	// 			found = true
-	// 		}
-	// 	}
-	//
-	// 	if found {
-	// 		fmt.Printf("%v -> %v\n", track.Path, metadataTrack.Title)
-	// 		artistName := metadataTrack.Recording.ArtistCredit[0].Name
-	// 		artists[artistName] = ""
-	// 		unprocessedTracks = append(unprocessedTracks, UnprocessedTrack{
-	// 			name:     metadataTrack.Title,
-	// 			number:   track.Number,
-	// 			filePath: track.Path,
-	// 			artist:   artistName,
-	// 		})
-	// 	}
-	// }
-	//
-	// for k := range artists {
-	// 	res, err := GetArtists(fmt.Sprintf("%%%v%%", k))
-	// 	if err != nil {
-	// 		log.Fatal(err)
-	// 	}
-	//
-	// 	fmt.Printf("Res: %v\n", res)
-	//
-	// 	if len(res.Artists) == 0 {
-	// 		artist, err := CreateArtist(k)
-	// 		if err != nil {
-	// 			log.Fatal(err)
-	// 		}
-	// 		artists[k] = artist.Id
-	// 	} else {
-	// 		if len(res.Artists) > 1 {
-	// 			log.Printf("Need to handle when the server returns multiple artists")
-	// 		}
-	//
-	// 		artist := res.Artists[0]
-	// 		artists[k] = artist.Id
-	// 	}
-	// }
-	//
-	// pretty.Println(unprocessedTracks)
-	//
-	// // dir, err := os.MkdirTemp(".", "testing")
-	// // if err != nil {
-	// // 	log.Fatal(err)
-	// // }
-	// // defer os.RemoveAll(dir)
-	//
-	// dir := "./testing"
-	// err = os.MkdirAll(dir, 0766)
-	// if err != nil {
-	// 	log.Fatal(err)
-	// }
-	//
-	// fmt.Printf("Dir: %v\n", dir)
-	//
-	// artistName := metadata.ArtistCredit[0].Name
-	//
-	// album, err := CreateAlbum(metadata.Title, artists[artistName])
-	// if err != nil {
-	// 	log.Fatal(err)
-	// }
-	//
-	// var processedTracks []Track
-	//
-	// for _, track := range unprocessedTracks {
-	// 	_ = track
-	// 	// TODO(patrik): Create Best Quality File (flac)
-	// 	// TODO(patrik): Create Mobile Quality File (flac)
-	//
-	// 	// TODO(patrik): Check extention
-	// 	dstName := fmt.Sprintf("%v.best.flac", track.number)
-	// 	bestQualityFilePath := path.Join(dir, dstName)
-	// 	utils.RunFFmpeg(true, "-i", track.filePath, bestQualityFilePath)
-	//
-	// 	// ffmpeg -i input.flac -ab 320k -map_metadata 0 -id3v2_version 3 output.mp3
-	// 	dstName = fmt.Sprintf("%v.mobile.mp3", track.number)
-	// 	mobileQualityFile := path.Join(dir, dstName)
-	// 	utils.RunFFmpeg(true, "-i", track.filePath, "-ab", "192k", mobileQualityFile)
-	//
-	// 	processedTracks = append(processedTracks, Track{
-	// 		name:                  track.name,
-	// 		number:                track.number,
-	// 		bestQualityFilePath:   bestQualityFilePath,
-	// 		mobileQualityFilePath: mobileQualityFile,
-	// 		coverArtFilePath:      "../test.png",
-	// 		albumId:               album.Id,
-	// 		artistId:              artists[track.artist],
-	// 	})
-	// }
-	//
-	// for _, track := range processedTracks {
-	// 	res, err := CreateTrack(track)
-	// 	if err != nil {
-	// 		log.Fatal(err)
-	// 	}
-	//
-	// 	pretty.Println(res)
-	// }
-
-	// pretty.Println(processedTracks)
-	// pretty.Println(artists)
+func main() {
+	if err := rootCmd.Execute(); err != nil {
+		log.Fatal(err)
+	}
 }
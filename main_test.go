@@ -0,0 +1,62 @@
+package main
+
+import "testing"
+
+func TestAlbumTrackNumber(t *testing.T) {
+	config := Config{}
+	for disc := 1; disc <= 2; disc++ {
+		for num := 1; num <= 6; num++ {
+			config.Tracks = append(config.Tracks, ConfigTrack{Num: num, Disc: disc})
+		}
+	}
+
+	seen := make(map[int]bool)
+	for i := range config.Tracks {
+		track := &config.Tracks[i]
+
+		number := config.AlbumTrackNumber(track)
+		want := (track.Disc-1)*6 + track.Num
+		if number != want {
+			t.Errorf("AlbumTrackNumber(%v-%v) = %v, want %v", track.Disc, track.Num, number, want)
+		}
+
+		if seen[number] {
+			t.Errorf("AlbumTrackNumber(%v-%v) = %v is used more then once", track.Disc, track.Num, number)
+		}
+		seen[number] = true
+	}
+}
+
+func TestAlbumTrackNumberSingleDisc(t *testing.T) {
+	config := Config{
+		Tracks: []ConfigTrack{{Num: 1}, {Num: 2}, {Num: 3}},
+	}
+
+	for i := range config.Tracks {
+		track := &config.Tracks[i]
+		if number := config.AlbumTrackNumber(track); number != track.Num {
+			t.Errorf("AlbumTrackNumber(%v) = %v, want %v", track.Num, number, track.Num)
+		}
+	}
+}
+
+func TestAlbumTrackNumberMissingTracks(t *testing.T) {
+	// NOTE(patrik): Track 2 is missing from the first disc
+	config := Config{
+		Tracks: []ConfigTrack{
+			{Num: 1, Disc: 1},
+			{Num: 3, Disc: 1},
+			{Num: 1, Disc: 2},
+			{Num: 2, Disc: 2},
+			{Num: 1, Disc: 3},
+		},
+	}
+
+	want := []int{1, 3, 4, 5, 6}
+	for i := range config.Tracks {
+		track := &config.Tracks[i]
+		if number := config.AlbumTrackNumber(track); number != want[i] {
+			t.Errorf("AlbumTrackNumber(%v-%v) = %v, want %v", track.Disc, track.Num, number, want[i])
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/nanoteck137/dwebble-importer/musicbrainz"
+)
+
+func creditArtistNames(credits musicbrainz.ArtistCredits) []string {
+	var names []string
+	for _, artist := range credits.Artists() {
+		names = append(names, artist.Name)
+	}
+
+	return names
+}
+
+func creditArtistMbids(credits musicbrainz.ArtistCredits) []string {
+	var ids []string
+	for _, artist := range credits.Artists() {
+		ids = append(ids, artist.Id)
+	}
+
+	return ids
+}
+
+func findReleaseTrack(metadata *musicbrainz.Metadata, disc, number int) (*musicbrainz.Track, bool) {
+	for i := range metadata.Media {
+		media := &metadata.Media[i]
+		if media.Position != disc {
+			continue
+		}
+
+		for j := range media.Tracks {
+			if media.Tracks[j].Position == number {
+				return &media.Tracks[j], true
+			}
+		}
+	}
+
+	return nil, false
+}
+
+// applyRelease fills in config with the metadata from a MusicBrainz
+// release, the local tracks are matched to the release by disc and
+// track position
+func applyRelease(config *Config, metadata *musicbrainz.Metadata) error {
+	releaseTrackCount := 0
+	for _, media := range metadata.Media {
+		releaseTrackCount += len(media.Tracks)
+	}
+
+	if releaseTrackCount != len(config.Tracks) {
+		return fmt.Errorf("Track count mismatch: release '%v' has %v tracks but found %v local tracks", metadata.Id, releaseTrackCount, len(config.Tracks))
+	}
+
+	for i := range config.Tracks {
+		track := &config.Tracks[i]
+
+		releaseTrack, found := findReleaseTrack(metadata, track.DiscNumber(), track.Num)
+		if !found {
+			return fmt.Errorf("No track %v on disc %v in release '%v' for '%v'", track.Num, track.DiscNumber(), metadata.Id, track.Filename)
+		}
+
+		track.Name = releaseTrack.Title
+		track.RecordingMbid = releaseTrack.Recording.Id
+
+		credit := releaseTrack.Credit()
+		if len(credit) > 0 {
+			track.Artist = credit.String()
+			track.Artists = creditArtistNames(credit)
+			track.ArtistMbids = creditArtistMbids(credit)
+		}
+	}
+
+	config.Name = metadata.Title
+	config.Date = metadata.Date
+	config.ReleaseMbid = metadata.Id
+	config.ReleaseGroupMbid = metadata.ReleaseGroup.Id
+
+	if len(metadata.ArtistCredit) > 0 {
+		config.Artist = metadata.ArtistCredit.String()
+		config.Artists = creditArtistNames(metadata.ArtistCredit)
+		config.ArtistMbids = creditArtistMbids(metadata.ArtistCredit)
+	}
+
+	for _, media := range metadata.Media {
+		if media.Title == "" {
+			continue
+		}
+
+		found := false
+		for i := range config.Discs {
+			if config.Discs[i].Num == media.Position {
+				config.Discs[i].Subtitle = media.Title
+				found = true
+			}
+		}
+
+		if !found {
+			config.Discs = append(config.Discs, ConfigDisc{
+				Num:      media.Position,
+				Subtitle: media.Title,
+			})
+		}
+	}
+
+	return nil
+}
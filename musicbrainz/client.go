@@ -0,0 +1,305 @@
+package musicbrainz
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket, a token is added every interval up to
+// burst tokens and every request takes one token
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	burst    float64
+	tokens   float64
+	last     time.Time
+}
+
+func newRateLimiter(interval time.Duration, burst int) *rateLimiter {
+	return &rateLimiter{
+		interval: interval,
+		burst:    float64(burst),
+		tokens:   float64(burst),
+		last:     time.Now(),
+	}
+}
+
+// Wait blocks until a token is available and takes it
+func (limiter *rateLimiter) Wait() {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	if limiter.interval <= 0 {
+		return
+	}
+
+	now := time.Now()
+	limiter.tokens += float64(now.Sub(limiter.last)) / float64(limiter.interval)
+	if limiter.tokens > limiter.burst {
+		limiter.tokens = limiter.burst
+	}
+	limiter.last = now
+
+	if limiter.tokens < 1 {
+		wait := time.Duration((1 - limiter.tokens) * float64(limiter.interval))
+		time.Sleep(wait)
+
+		limiter.tokens = 1
+		limiter.last = time.Now()
+	}
+
+	limiter.tokens -= 1
+}
+
+// Delay makes the limiter wait for d before handing out new tokens, used
+// when the server tells us to back off
+func (limiter *rateLimiter) Delay(d time.Duration) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	if limiter.interval <= 0 {
+		time.Sleep(d)
+		return
+	}
+
+	limiter.tokens = -float64(d) / float64(limiter.interval)
+	limiter.last = time.Now()
+}
+
+type cache struct {
+	dir string
+	ttl time.Duration
+}
+
+func (c *cache) path(key string) string {
+	return path.Join(c.dir, key)
+}
+
+// get returns the cached data for key if it exists and is younger than
+// the ttl
+func (c *cache) get(key string) ([]byte, bool) {
+	p := c.path(key)
+
+	info, err := os.Stat(p)
+	if err != nil {
+		return nil, false
+	}
+
+	if time.Since(info.ModTime()) > c.ttl {
+		return nil, false
+	}
+
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, false
+	}
+
+	return data, true
+}
+
+func (c *cache) put(key string, data []byte) error {
+	p := c.path(key)
+
+	err := os.MkdirAll(path.Dir(p), 0755)
+	if err != nil {
+		return err
+	}
+
+	tmp := p + ".tmp"
+	err = os.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, p)
+}
+
+// Client talks to MusicBrainz and the Cover Art Archive, all requests
+// made through the same client share one rate limiter
+type Client struct {
+	httpClient     *http.Client
+	userAgent      string
+	musicbrainzUrl string
+	coverArtUrl    string
+
+	limiter    *rateLimiter
+	cache      *cache
+	maxRetries int
+}
+
+type Option func(client *Client)
+
+const (
+	DefaultMusicBrainzUrl = "https://musicbrainz.org/ws/2"
+	DefaultCoverArtUrl    = "https://coverartarchive.org"
+	DefaultUserAgent      = "dwebble/0.0.1 ( github.com/nanoteck137/dwebble )"
+)
+
+// WithMusicBrainzUrl sets the base url of the MusicBrainz web service,
+// including the version (e.g. https://musicbrainz.org/ws/2)
+func WithMusicBrainzUrl(url string) Option {
+	return func(client *Client) {
+		client.musicbrainzUrl = strings.TrimSuffix(url, "/")
+	}
+}
+
+// WithCoverArtUrl sets the base url of the Cover Art Archive
+func WithCoverArtUrl(url string) Option {
+	return func(client *Client) {
+		client.coverArtUrl = strings.TrimSuffix(url, "/")
+	}
+}
+
+// WithUserAgent sets the user agent, MusicBrainz requires it to identify
+// the application and a way to contact the developer
+func WithUserAgent(userAgent string) Option {
+	return func(client *Client) {
+		client.userAgent = userAgent
+	}
+}
+
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(client *Client) {
+		client.httpClient = httpClient
+	}
+}
+
+// WithCache caches releases and cover art in dir, cached entries older
+// than ttl are fetched again
+func WithCache(dir string, ttl time.Duration) Option {
+	return func(client *Client) {
+		client.cache = &cache{
+			dir: dir,
+			ttl: ttl,
+		}
+	}
+}
+
+// WithRateLimit allows burst requests and then one request every
+// interval
+func WithRateLimit(interval time.Duration, burst int) Option {
+	return func(client *Client) {
+		client.limiter = newRateLimiter(interval, burst)
+	}
+}
+
+func NewClient(options ...Option) *Client {
+	client := &Client{
+		httpClient: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+		userAgent:      DefaultUserAgent,
+		musicbrainzUrl: DefaultMusicBrainzUrl,
+		coverArtUrl:    DefaultCoverArtUrl,
+		// NOTE(patrik): MusicBrainz allows one request per second
+		limiter:    newRateLimiter(time.Second, 1),
+		maxRetries: 3,
+	}
+
+	for _, option := range options {
+		option(client)
+	}
+
+	return client
+}
+
+var defaultClient = NewClient()
+
+func (client *Client) cacheGet(key string) ([]byte, bool) {
+	if client.cache == nil {
+		return nil, false
+	}
+
+	return client.cache.get(key)
+}
+
+func (client *Client) cachePut(key string, data []byte) {
+	if client.cache == nil {
+		return
+	}
+
+	// NOTE(patrik): A failed cache write only means we need to fetch the
+	// data again next time
+	client.cache.put(key, data)
+}
+
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if t, err := http.ParseTime(value); err == nil {
+		return time.Until(t), true
+	}
+
+	return 0, false
+}
+
+// get sends a rate limited GET request, when the server responds with
+// 503 or 429 the request is retried after the time the server asks for
+func (client *Client) get(url string) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		client.limiter.Wait()
+
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("User-Agent", client.userAgent)
+
+		res, err := client.httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if res.StatusCode != http.StatusServiceUnavailable && res.StatusCode != http.StatusTooManyRequests {
+			return res, nil
+		}
+
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+		res.Body.Close()
+
+		if attempt >= client.maxRetries {
+			return nil, newResponseError(url, res.StatusCode, body)
+		}
+
+		wait, ok := parseRetryAfter(res.Header.Get("Retry-After"))
+		if !ok || wait < time.Second {
+			wait = time.Duration(attempt+1) * time.Second
+		}
+
+		client.limiter.Delay(wait)
+	}
+}
+
+// fetch sends a GET request and reads the whole response, every status
+// other then 200 is returned as a ResponseError
+func (client *Client) fetch(url string) (*http.Response, []byte, error) {
+	res, err := client.get(url)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return nil, nil, newResponseError(url, res.StatusCode, data)
+	}
+
+	return res, data, nil
+}
@@ -0,0 +1,105 @@
+package musicbrainz
+
+import (
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter := newRateLimiter(50*time.Millisecond, 2)
+
+	// NOTE(patrik): The burst is handed out right away
+	start := time.Now()
+	limiter.Wait()
+	limiter.Wait()
+	if elapsed := time.Since(start); elapsed > 25*time.Millisecond {
+		t.Errorf("Burst took %v, want no wait", elapsed)
+	}
+
+	start = time.Now()
+	limiter.Wait()
+	limiter.Wait()
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("Two waits after the burst took %v, want at least 100ms", elapsed)
+	}
+
+	// NOTE(patrik): Tokens are added back while idle but never more then
+	// the burst
+	time.Sleep(200 * time.Millisecond)
+
+	start = time.Now()
+	limiter.Wait()
+	limiter.Wait()
+	if elapsed := time.Since(start); elapsed > 25*time.Millisecond {
+		t.Errorf("Burst after being idle took %v, want no wait", elapsed)
+	}
+
+	start = time.Now()
+	limiter.Wait()
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("Wait after the burst took %v, want at least 50ms", elapsed)
+	}
+}
+
+func TestRateLimiterDelay(t *testing.T) {
+	limiter := newRateLimiter(10*time.Millisecond, 5)
+	limiter.Delay(100 * time.Millisecond)
+
+	start := time.Now()
+	limiter.Wait()
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Wait after Delay took %v, want at least 100ms", elapsed)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	wait, ok := parseRetryAfter("5")
+	if !ok || wait != 5*time.Second {
+		t.Errorf("parseRetryAfter(\"5\") = %v, %v, want 5s, true", wait, ok)
+	}
+
+	date := time.Now().Add(10 * time.Second).UTC().Format(http.TimeFormat)
+	wait, ok = parseRetryAfter(date)
+	if !ok || wait < 8*time.Second || wait > 10*time.Second {
+		t.Errorf("parseRetryAfter(%q) = %v, %v, want about 10s, true", date, wait, ok)
+	}
+
+	for _, value := range []string{"", "soon"} {
+		if _, ok := parseRetryAfter(value); ok {
+			t.Errorf("parseRetryAfter(%q) should fail", value)
+		}
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	c := &cache{
+		dir: t.TempDir(),
+		ttl: time.Hour,
+	}
+
+	if _, ok := c.get("release/missing.json"); ok {
+		t.Error("get returned a missing entry")
+	}
+
+	err := c.put("release/id.json", []byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, ok := c.get("release/id.json")
+	if !ok || string(data) != "data" {
+		t.Errorf("get = %q, %v, want \"data\", true", data, ok)
+	}
+
+	old := time.Now().Add(-2 * time.Hour)
+	err = os.Chtimes(c.path("release/id.json"), old, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := c.get("release/id.json"); ok {
+		t.Error("get returned an expired entry")
+	}
+}
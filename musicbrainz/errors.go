@@ -0,0 +1,68 @@
+package musicbrainz
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var (
+	ErrNotFound          = errors.New("not found")
+	ErrRateLimited       = errors.New("rate limited")
+	ErrMalformedResponse = errors.New("malformed response")
+)
+
+// ResponseError is returned when MusicBrainz or the Cover Art Archive
+// responds with an unexpected status, use errors.Is with ErrNotFound or
+// ErrRateLimited to check what kind of error it is
+type ResponseError struct {
+	Url        string
+	StatusCode int
+	Message    string
+}
+
+func (err *ResponseError) Error() string {
+	if err.Message != "" {
+		return fmt.Sprintf("GET %v: %v %v", err.Url, err.StatusCode, err.Message)
+	}
+
+	return fmt.Sprintf("GET %v: %v", err.Url, err.StatusCode)
+}
+
+func (err *ResponseError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return err.StatusCode == http.StatusNotFound
+	case ErrRateLimited:
+		return err.StatusCode == http.StatusTooManyRequests ||
+			err.StatusCode == http.StatusServiceUnavailable
+	}
+
+	return false
+}
+
+type errorPayload struct {
+	Error string `json:"error"`
+}
+
+func newResponseError(url string, statusCode int, body []byte) *ResponseError {
+	responseErr := &ResponseError{
+		Url:        url,
+		StatusCode: statusCode,
+	}
+
+	// NOTE(patrik): MusicBrainz sends {"error": "..."}, the Cover Art
+	// Archive sends html so only json errors are kept
+	var payload errorPayload
+	if err := json.Unmarshal(body, &payload); err == nil {
+		responseErr.Message = payload.Error
+	}
+
+	return responseErr
+}
+
+// malformedError wraps err so it matches ErrMalformedResponse
+func malformedError(url string, err error) error {
+	return fmt.Errorf("%w from %v: %w", ErrMalformedResponse, url, err)
+}
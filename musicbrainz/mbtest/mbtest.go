@@ -0,0 +1,282 @@
+// Package mbtest provides a local stand-in for MusicBrainz and the Cover
+// Art Archive that serves recorded responses, so code using the
+// musicbrainz package can run without network access
+package mbtest
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nanoteck137/dwebble-importer/musicbrainz"
+)
+
+//go:embed fixtures
+var fixtures embed.FS
+
+// MetallicaReleaseId is the release included in the recorded fixtures
+const MetallicaReleaseId = "2529f558-970b-33d2-a42c-41ab15a970c6"
+
+// MetallicaDiscId is the disc id of the CD of MetallicaReleaseId
+const MetallicaDiscId = "WqojhGSt9rnDPUrbTm5rR6yx7KA-"
+
+type cover struct {
+	contentType string
+	data        []byte
+}
+
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	releases map[string][]byte
+	discIds  map[string][]byte
+	covers   map[string]cover
+	search   []byte
+	requests int
+
+	unavailable int
+	retryAfter  string
+}
+
+// NewServer starts a server with the recorded fixtures loaded, close it
+// with Close when done
+func NewServer() *Server {
+	server := &Server{
+		releases: make(map[string][]byte),
+		discIds:  make(map[string][]byte),
+		covers:   make(map[string]cover),
+	}
+
+	server.loadFixtures()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws/2/release/", server.handleRelease)
+	mux.HandleFunc("/ws/2/release", server.handleSearch)
+	mux.HandleFunc("/ws/2/discid/", server.handleDiscId)
+	mux.HandleFunc("/release/", server.handleCover)
+	mux.HandleFunc("/release-group/", server.handleCover)
+
+	server.Server = httptest.NewServer(server.handleUnavailable(mux))
+	return server
+}
+
+func (server *Server) loadFixtures() {
+	entries, _ := fs.ReadDir(fixtures, "fixtures/release")
+	for _, entry := range entries {
+		data, err := fixtures.ReadFile(path.Join("fixtures/release", entry.Name()))
+		if err != nil {
+			continue
+		}
+
+		server.releases[strings.TrimSuffix(entry.Name(), ".json")] = data
+	}
+
+	entries, _ = fs.ReadDir(fixtures, "fixtures/discid")
+	for _, entry := range entries {
+		data, err := fixtures.ReadFile(path.Join("fixtures/discid", entry.Name()))
+		if err != nil {
+			continue
+		}
+
+		server.discIds[strings.TrimSuffix(entry.Name(), ".json")] = data
+	}
+
+	entries, _ = fs.ReadDir(fixtures, "fixtures/cover")
+	for _, entry := range entries {
+		data, err := fixtures.ReadFile(path.Join("fixtures/cover", entry.Name()))
+		if err != nil {
+			continue
+		}
+
+		ext := path.Ext(entry.Name())
+		contentType := "image/png"
+		if ext == ".jpg" {
+			contentType = "image/jpeg"
+		}
+
+		server.covers[strings.TrimSuffix(entry.Name(), ext)] = cover{
+			contentType: contentType,
+			data:        data,
+		}
+	}
+
+	server.search, _ = fixtures.ReadFile("fixtures/search.json")
+}
+
+// Client returns a musicbrainz client pointed at the server without any
+// rate limiting
+func (server *Server) Client(options ...musicbrainz.Option) *musicbrainz.Client {
+	options = append([]musicbrainz.Option{
+		musicbrainz.WithMusicBrainzUrl(server.URL + "/ws/2"),
+		musicbrainz.WithCoverArtUrl(server.URL),
+		musicbrainz.WithRateLimit(0, 1),
+		musicbrainz.WithHTTPClient(&http.Client{Timeout: 5 * time.Second}),
+	}, options...)
+
+	return musicbrainz.NewClient(options...)
+}
+
+// AddRelease serves data as the release json for mbid
+func (server *Server) AddRelease(mbid string, data []byte) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	server.releases[mbid] = data
+}
+
+// AddDiscId serves data as the disc id lookup response for discId
+func (server *Server) AddDiscId(discId string, data []byte) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	server.discIds[discId] = data
+}
+
+// AddCover serves data as the front cover for mbid, which can be either
+// a release or a release group. Every thumbnail size gets the same data
+func (server *Server) AddCover(mbid, contentType string, data []byte) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	server.covers[mbid] = cover{
+		contentType: contentType,
+		data:        data,
+	}
+}
+
+// SetSearch sets the response for release searches
+func (server *Server) SetSearch(data []byte) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	server.search = data
+}
+
+// SetUnavailable makes the server respond with 503 to the next n
+// requests, the way MusicBrainz does when it's overloaded. retryAfter is
+// sent as the Retry-After header when it's set
+func (server *Server) SetUnavailable(n int, retryAfter string) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	server.unavailable = n
+	server.retryAfter = retryAfter
+}
+
+// Requests returns how many requests the server has handled
+func (server *Server) Requests() int {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	return server.requests
+}
+
+func writeNotFound(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusNotFound)
+	w.Write([]byte(`{"error":"Not Found","help":"For usage, please see: https://musicbrainz.org/development/mmd"}`))
+}
+
+func (server *Server) handleUnavailable(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.mu.Lock()
+		unavailable := server.unavailable > 0
+		if unavailable {
+			server.unavailable--
+			server.requests++
+		}
+		retryAfter := server.retryAfter
+		server.mu.Unlock()
+
+		if !unavailable {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if retryAfter != "" {
+			w.Header().Set("Retry-After", retryAfter)
+		}
+
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+}
+
+func (server *Server) handleRelease(w http.ResponseWriter, r *http.Request) {
+	server.mu.Lock()
+	server.requests++
+	data, exists := server.releases[path.Base(r.URL.Path)]
+	server.mu.Unlock()
+
+	if !exists {
+		writeNotFound(w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+func (server *Server) handleDiscId(w http.ResponseWriter, r *http.Request) {
+	server.mu.Lock()
+	server.requests++
+	data, exists := server.discIds[path.Base(r.URL.Path)]
+	server.mu.Unlock()
+
+	if !exists {
+		writeNotFound(w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+func (server *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	server.mu.Lock()
+	server.requests++
+	data := server.search
+	server.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+func (server *Server) handleCover(w http.ResponseWriter, r *http.Request) {
+	// NOTE(patrik): /release/{mbid}/front or /release-group/{mbid}/front,
+	// thumbnails are front-250, front-500 and front-1200
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	server.mu.Lock()
+	server.requests++
+	var c cover
+	exists := false
+	if len(parts) == 3 && isFront(parts[2]) {
+		c, exists = server.covers[parts[1]]
+	}
+	server.mu.Unlock()
+
+	if !exists {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("<html><body>Not Found</body></html>"))
+		return
+	}
+
+	w.Header().Set("Content-Type", c.contentType)
+	w.Write(c.data)
+}
+
+func isFront(name string) bool {
+	switch name {
+	case "front", "front-250", "front-500", "front-1200":
+		return true
+	}
+
+	return false
+}
@@ -1,65 +1,100 @@
 package musicbrainz
 
 import (
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
+	neturl "net/url"
+	"path"
+	"strings"
 )
 
+type Artist struct {
+	Id             string `json:"id"`
+	Name           string `json:"name"`
+	SortName       string `json:"sort-name"`
+	Type           string `json:"type"`
+	Disambiguation string `json:"disambiguation"`
+}
+
+// ArtistCredit is one artist in a credit, Name is the name the artist is
+// credited as and JoinPhrase is the text put between it and the next
+// artist (e.g. " feat. " or " & ")
+type ArtistCredit struct {
+	Name       string `json:"name"`
+	JoinPhrase string `json:"joinphrase"`
+	Artist     Artist `json:"artist"`
+}
+
+type ArtistCredits []ArtistCredit
+
+// String renders the credit the way it's displayed on MusicBrainz, e.g.
+// "A feat. B & C"
+func (credits ArtistCredits) String() string {
+	var b strings.Builder
+
+	for _, credit := range credits {
+		name := credit.Name
+		if name == "" {
+			name = credit.Artist.Name
+		}
+
+		b.WriteString(name)
+		b.WriteString(credit.JoinPhrase)
+	}
+
+	return b.String()
+}
+
+// Artists returns every credited artist in order
+func (credits ArtistCredits) Artists() []Artist {
+	artists := make([]Artist, 0, len(credits))
+	for _, credit := range credits {
+		artists = append(artists, credit.Artist)
+	}
+
+	return artists
+}
+
 type Track struct {
 	Id       string `json:"id"`
 	Title    string `json:"title"`
 	Number   string `json:"number"`
 	Position int    `json:"position"`
+	Length   int    `json:"length"`
 
-	//	          "number": "2",
-	//	          "length": 324600,
-	//	          "position": 2,
-	//	          "title": "Sad but True",
+	// NOTE(patrik): The credit for the track on this release, can differ
+	// from the credit of the recording
+	ArtistCredit ArtistCredits `json:"artist-credit"`
 
 	Recording struct {
-		ArtistCredit []struct {
-			Id   string `json:"id"`
-			Name string `json:"name"`
-			Type string `json:"type"`
-			//	                "joinphrase": "",
-			//	                "name": "Metallica",
-			//	                "artist": {
-			//	                  "type": "Group",
-			//	                  "sort-name": "Metallica",
-			//	                  "type-id": "e431f5f6-b5d2-343d-8b36-72607fffb74b",
-			//	                  "disambiguation": "",
-			//	                  "name": "Metallica",
-			//	                  "id": "65f4f0c5-ef9e-490c-aee3-909e7ae6b2ab"
-			//	                }
-		} `json:"artist-credit"`
-		Disambiguation   string `json:"disambiguation"`
-		Title            string `json:"title"`
-		Length           int    `json:"length"`
-		Id               string `json:"id"`
-		FirstReleaseDate string `json:"first-release-date"`
-		Video            bool   `json:"video"`
+		ArtistCredit     ArtistCredits `json:"artist-credit"`
+		Disambiguation   string        `json:"disambiguation"`
+		Title            string        `json:"title"`
+		Length           int           `json:"length"`
+		Id               string        `json:"id"`
+		FirstReleaseDate string        `json:"first-release-date"`
+		Video            bool          `json:"video"`
 	} `json:"recording"`
+}
+
+// Credit returns the artist credit of the track, falling back to the
+// recording credit
+func (track *Track) Credit() ArtistCredits {
+	if len(track.ArtistCredit) > 0 {
+		return track.ArtistCredit
+	}
 
-	//	          "number": "2",
-	//	          "length": 324600,
-	//	          "position": 2,
-	//	          "title": "Sad but True",
-	//	          "artist-credit": [
-	//	            {
-	//	              "joinphrase": "",
-	//	              "name": "Metallica",
-	//	              "artist": {
-	//	                "id": "65f4f0c5-ef9e-490c-aee3-909e7ae6b2ab",
-	//	                "name": "Metallica",
-	//	                "type-id": "e431f5f6-b5d2-343d-8b36-72607fffb74b",
-	//	                "disambiguation": "",
-	//	                "sort-name": "Metallica",
-	//	                "type": "Group"
-	//	              }
-	//	            }
-	//	          ]
+	return track.Recording.ArtistCredit
+}
+
+type Disc struct {
+	Id          string `json:"id"`
+	Sectors     int    `json:"sectors"`
+	OffsetCount int    `json:"offset-count"`
+	Offsets     []int  `json:"offsets"`
 }
 
 type Media struct {
@@ -68,26 +103,40 @@ type Media struct {
 	Position int    `json:"position"`
 	Format   string `json:"format"`
 
-	TrackCount  string `json:"track-count"`
-	TrackOffset string `json:"track-offset"`
+	TrackCount  int `json:"track-count"`
+	TrackOffset int `json:"track-offset"`
 
 	Tracks []Track `json:"tracks"`
+	Discs  []Disc  `json:"discs"`
+}
+
+// HasDisc checks if discId is one of the disc ids attached to the media
+func (media *Media) HasDisc(discId string) bool {
+	for _, disc := range media.Discs {
+		if disc.Id == discId {
+			return true
+		}
+	}
+
+	return false
+}
+
+type ReleaseGroup struct {
+	Id               string `json:"id"`
+	Title            string `json:"title"`
+	PrimaryType      string `json:"primary-type"`
+	FirstReleaseDate string `json:"first-release-date"`
 }
 
 type Metadata struct {
-	Id    string  `json:"id"`
-	Title string  `json:"title"`
-	Date  string  `json:"date"`
-	Media []Media `json:"media"`
-
-	ArtistCredit []struct {
-		Name   string `json:"name"`
-		Artist struct {
-			Id   string `json:"id"`
-			Name string `json:"name"`
-			Type string `json:"type"`
-		} `json:"arist"`
-	} `json:"artist-credit"`
+	Id      string  `json:"id"`
+	Title   string  `json:"title"`
+	Date    string  `json:"date"`
+	Country string  `json:"country"`
+	Media   []Media `json:"media"`
+
+	ArtistCredit ArtistCredits `json:"artist-credit"`
+	ReleaseGroup ReleaseGroup  `json:"release-group"`
 
 	//	{
 	//	  "packaging-id": null,
@@ -141,71 +190,268 @@ type CoverArtResponse struct {
 	Data []byte
 }
 
-func FetchCoverArt(mbid string) (CoverArtResponse, error) {
-	// https://coverartarchive.org/release/{mbid}/front
-	url := fmt.Sprintf("https://coverartarchive.org/release/%v/front", mbid)
-	req, err := http.NewRequest(http.MethodGet, url, nil)
-	if err != nil {
-		return CoverArtResponse{}, err
-	}
+// CoverSize selects which thumbnail the Cover Art Archive returns, the
+// thumbnails are scaled so the longest edge is the size in pixels
+type CoverSize string
 
-	req.Header.Set("User-Agent", "dwebble/0.0.1 ( github.com/nanoteck137/dwebble )")
+const (
+	CoverSize250      CoverSize = "250"
+	CoverSize500      CoverSize = "500"
+	CoverSize1200     CoverSize = "1200"
+	CoverSizeOriginal CoverSize = ""
+)
 
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return CoverArtResponse{}, err
+func (size CoverSize) front() string {
+	if size == CoverSizeOriginal {
+		return "front"
 	}
-	defer res.Body.Close()
 
-	contentType := res.Header.Get("Content-Type")
-	var ext string
+	return "front-" + string(size)
+}
 
+func FetchCoverArt(mbid string, size CoverSize) (CoverArtResponse, error) {
+	return defaultClient.FetchCoverArt(mbid, size)
+}
+
+func coverArtExt(contentType string) (string, error) {
 	switch contentType {
 	case "image/jpeg":
-		ext = "jpg"
+		return "jpg", nil
 	case "image/png":
-		ext = "png"
+		return "png", nil
 	default:
-		return CoverArtResponse{}, fmt.Errorf("Unknown content type for cover art: %v\n", contentType)
+		return "", fmt.Errorf("Unknown content type for cover art: %v", contentType)
 	}
+}
 
-	data, err := io.ReadAll(res.Body)
+func (client *Client) fetchCover(url string) (CoverArtResponse, error) {
+	res, data, err := client.fetch(url)
 	if err != nil {
 		return CoverArtResponse{}, err
 	}
 
+	ext, err := coverArtExt(res.Header.Get("Content-Type"))
+	if err != nil {
+		// NOTE(patrik): Mirrors can send a generic content type, trust the
+		// data over the header
+		ext, err = coverArtExt(http.DetectContentType(data))
+		if err != nil {
+			return CoverArtResponse{}, malformedError(url, err)
+		}
+	}
+
 	return CoverArtResponse{
 		Ext:  ext,
 		Data: data,
-	}, err
+	}, nil
+}
+
+// FetchCoverArt downloads the front cover of a release, when the release
+// has no front cover the front cover of its release group is used
+// instead. Returns ErrNotFound if neither has one
+func (client *Client) FetchCoverArt(mbid string, size CoverSize) (CoverArtResponse, error) {
+	cacheKey := path.Join("cover", mbid)
+	if size != CoverSizeOriginal {
+		cacheKey += "-" + string(size)
+	}
+
+	if data, ok := client.cacheGet(cacheKey); ok {
+		ext, err := coverArtExt(http.DetectContentType(data))
+		if err == nil {
+			return CoverArtResponse{
+				Ext:  ext,
+				Data: data,
+			}, nil
+		}
+	}
+
+	// https://coverartarchive.org/release/{mbid}/front
+	url := fmt.Sprintf("%v/release/%v/%v", client.coverArtUrl, mbid, size.front())
+
+	cover, err := client.fetchCover(url)
+	if errors.Is(err, ErrNotFound) {
+		metadata, metadataErr := client.FetchAlbumMetadata(mbid)
+		if metadataErr != nil {
+			return CoverArtResponse{}, metadataErr
+		}
+
+		if metadata.ReleaseGroup.Id == "" {
+			return CoverArtResponse{}, err
+		}
+
+		// https://coverartarchive.org/release-group/{mbid}/front
+		url = fmt.Sprintf("%v/release-group/%v/%v", client.coverArtUrl, metadata.ReleaseGroup.Id, size.front())
+		cover, err = client.fetchCover(url)
+	}
+
+	if err != nil {
+		return CoverArtResponse{}, err
+	}
+
+	client.cachePut(cacheKey, cover.Data)
+
+	return cover, nil
 }
 
 func FetchAlbumMetadata(mbid string) (Metadata, error) {
-	// https://musicbrainz.org/ws/2/release/{mbid}?inc=artist-credits%2Brecordings&fmt=json
-	url := fmt.Sprintf("https://musicbrainz.org/ws/2/release/%v?inc=artist-credits+recordings&fmt=json", mbid)
+	return defaultClient.FetchAlbumMetadata(mbid)
+}
+
+func (client *Client) FetchAlbumMetadata(mbid string) (Metadata, error) {
+	cacheKey := path.Join("release", mbid+".json")
+
+	// https://musicbrainz.org/ws/2/release/{mbid}?inc=artist-credits%2Brecordings%2Brelease-groups&fmt=json
+	url := fmt.Sprintf("%v/release/%v?inc=artist-credits+recordings+release-groups&fmt=json", client.musicbrainzUrl, mbid)
 
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+	data, cached := client.cacheGet(cacheKey)
+	if !cached {
+		var err error
+		_, data, err = client.fetch(url)
+		if err != nil {
+			return Metadata{}, err
+		}
+	}
+
+	var metadata Metadata
+	err := json.Unmarshal(data, &metadata)
 	if err != nil {
-		return Metadata{}, err
+		return Metadata{}, malformedError(url, err)
 	}
 
-	req.Header.Set("User-Agent", "dwebble/0.0.1 ( github.com/nanoteck137/dwebble )")
+	if metadata.Id == "" {
+		return Metadata{}, malformedError(url, errors.New("release has no id"))
+	}
+
+	if !cached {
+		client.cachePut(cacheKey, data)
+	}
+
+	return metadata, nil
+}
+
+type discIdResponse struct {
+	Releases []Metadata `json:"releases"`
+}
+
+// LookupDiscId finds the releases with a medium that has the disc id, see
+// LookupDiscId on Client
+func LookupDiscId(discId, toc string) ([]Metadata, error) {
+	return defaultClient.LookupDiscId(discId, toc)
+}
+
+// LookupDiscId finds the releases with a medium that has the disc id, when
+// toc is set and MusicBrainz doesn't know the disc id the releases with a
+// similar TOC are returned instead
+func (client *Client) LookupDiscId(discId, toc string) ([]Metadata, error) {
+	// NOTE(patrik): With a toc the response also has the fuzzy matches,
+	// so it's cached apart from the lookup of only the disc id
+	cacheKey := path.Join("discid", discId+".json")
+	if toc != "" {
+		hash := sha256.Sum256([]byte(toc))
+		cacheKey = path.Join("discid", fmt.Sprintf("%v-%x.json", discId, hash[:8]))
+	}
+
+	// https://musicbrainz.org/ws/2/discid/{discid}?toc={toc}&inc=artist-credits%2Brecordings%2Brelease-groups&fmt=json
+	url := fmt.Sprintf("%v/discid/%v?inc=artist-credits+recordings+release-groups&cdstubs=no&fmt=json", client.musicbrainzUrl, neturl.PathEscape(discId))
+	if toc != "" {
+		url += "&toc=" + neturl.QueryEscape(toc)
+	}
+
+	data, cached := client.cacheGet(cacheKey)
+	if !cached {
+		var err error
+		_, data, err = client.fetch(url)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	res, err := http.DefaultClient.Do(req)
+	var response discIdResponse
+	err := json.Unmarshal(data, &response)
 	if err != nil {
-		return Metadata{}, err
+		return nil, malformedError(url, err)
 	}
-	defer res.Body.Close()
 
-	data, err := io.ReadAll(res.Body)
+	if !cached {
+		client.cachePut(cacheKey, data)
+	}
+
+	return response.Releases, nil
+}
+
+type SearchRelease struct {
+	Id         string `json:"id"`
+	Score      int    `json:"score"`
+	Title      string `json:"title"`
+	Date       string `json:"date"`
+	Country    string `json:"country"`
+	Status     string `json:"status"`
+	TrackCount int    `json:"track-count"`
+
+	ArtistCredit ArtistCredits `json:"artist-credit"`
+
+	Media []struct {
+		Format     string `json:"format"`
+		TrackCount int    `json:"track-count"`
+	} `json:"media"`
+}
+
+type searchResponse struct {
+	Count    int             `json:"count"`
+	Releases []SearchRelease `json:"releases"`
+}
+
+var luceneEscaper = strings.NewReplacer(
+	`\`, `\\`, `+`, `\+`, `-`, `\-`, `!`, `\!`, `(`, `\(`, `)`, `\)`,
+	`:`, `\:`, `^`, `\^`, `[`, `\[`, `]`, `\]`, `"`, `\"`, `{`, `\{`,
+	`}`, `\}`, `~`, `\~`, `*`, `\*`, `?`, `\?`, `|`, `\|`, `&`, `\&`,
+	`/`, `\/`,
+)
+
+// SearchReleases searches for releases by album title and artist, when
+// trackCount is above 0 it's used to narrow down the search
+func SearchReleases(album, artist string, trackCount int) ([]SearchRelease, error) {
+	return defaultClient.SearchReleases(album, artist, trackCount)
+}
+
+// SearchReleases searches for releases by album title and artist, when
+// trackCount is above 0 it's used to narrow down the search
+func (client *Client) SearchReleases(album, artist string, trackCount int) ([]SearchRelease, error) {
+	var parts []string
+
+	if album != "" {
+		parts = append(parts, fmt.Sprintf(`release:"%v"`, luceneEscaper.Replace(album)))
+	}
+
+	if artist != "" {
+		parts = append(parts, fmt.Sprintf(`artist:"%v"`, luceneEscaper.Replace(artist)))
+	}
+
+	if trackCount > 0 {
+		parts = append(parts, fmt.Sprintf("tracks:%v", trackCount))
+	}
+
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("Nothing to search for")
+	}
+
+	query := strings.Join(parts, " AND ")
+
+	// https://musicbrainz.org/ws/2/release?query={query}&fmt=json
+	url := fmt.Sprintf("%v/release?query=%v&limit=10&fmt=json", client.musicbrainzUrl, neturl.QueryEscape(query))
+
+	_, data, err := client.fetch(url)
 	if err != nil {
-		return Metadata{}, err
+		return nil, err
 	}
 
-	var metadata Metadata
-	json.Unmarshal(data, &metadata)
+	var response searchResponse
+	err = json.Unmarshal(data, &response)
+	if err != nil {
+		return nil, malformedError(url, err)
+	}
 
-	return metadata, nil
+	return response.Releases, nil
 }
 
 func (metadata *Metadata) DebugDump() {
@@ -216,7 +462,7 @@ func (metadata *Metadata) DebugDump() {
 
 		for _, t := range m.Tracks {
 			fmt.Printf("    %v", t.Recording.Title)
-			fmt.Printf(" - %v\n", t.Recording.ArtistCredit[0].Name)
+			fmt.Printf(" - %v\n", t.Credit())
 		}
 	}
 }
@@ -0,0 +1,458 @@
+package musicbrainz_test
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nanoteck137/dwebble-importer/musicbrainz"
+	"github.com/nanoteck137/dwebble-importer/musicbrainz/mbtest"
+)
+
+const splitReleaseId = "3f0a7c1e-2b4d-4c8e-9a61-5d2e8b7c9f10"
+const splitReleaseGroupId = "7c9e2d41-6a3b-4f58-8e12-0b4d6f1a2c35"
+
+// NOTE(patrik): A release with two media and a track credited to more
+// then one artist, the recorded fixtures only have single artist releases
+var splitRelease = []byte(`{
+	"id": "` + splitReleaseId + `",
+	"title": "Split",
+	"date": "2004-05-17",
+	"artist-credit": [
+		{"name": "Artist A", "joinphrase": " & ", "artist": {"id": "a", "name": "Artist A"}},
+		{"name": "B", "joinphrase": "", "artist": {"id": "b", "name": "Artist B"}}
+	],
+	"release-group": {
+		"id": "` + splitReleaseGroupId + `",
+		"title": "Split",
+		"primary-type": "Album",
+		"first-release-date": "2004-05-17"
+	},
+	"media": [
+		{
+			"position": 1,
+			"format": "CD",
+			"track-count": 1,
+			"track-offset": 0,
+			"tracks": [
+				{
+					"id": "t1",
+					"number": "1",
+					"position": 1,
+					"title": "First",
+					"length": 181000,
+					"recording": {
+						"id": "r1",
+						"title": "First",
+						"length": 180500,
+						"artist-credit": [
+							{"name": "Artist A", "joinphrase": "", "artist": {"id": "a", "name": "Artist A"}}
+						]
+					}
+				}
+			]
+		},
+		{
+			"position": 2,
+			"format": "Digital Media",
+			"track-count": 1,
+			"track-offset": 0,
+			"tracks": [
+				{
+					"id": "t2",
+					"number": "A1",
+					"position": 1,
+					"title": "Second",
+					"length": 240000,
+					"artist-credit": [
+						{"name": "Artist A", "joinphrase": " feat. ", "artist": {"id": "a", "name": "Artist A"}},
+						{"name": "", "joinphrase": "", "artist": {"id": "c", "name": "Artist C"}}
+					],
+					"recording": {
+						"id": "r2",
+						"title": "Second",
+						"length": 240000
+					}
+				}
+			]
+		}
+	]
+}`)
+
+func TestFetchAlbumMetadata(t *testing.T) {
+	server := mbtest.NewServer()
+	defer server.Close()
+
+	metadata, err := server.Client().FetchAlbumMetadata(mbtest.MetallicaReleaseId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if metadata.Id != mbtest.MetallicaReleaseId {
+		t.Errorf("Id = %q, want %q", metadata.Id, mbtest.MetallicaReleaseId)
+	}
+
+	if metadata.Title != "Metallica" || metadata.Date != "1991-08-12" || metadata.Country != "CA" {
+		t.Errorf("Unexpected release: %q %q %q", metadata.Title, metadata.Date, metadata.Country)
+	}
+
+	if metadata.ReleaseGroup.Id != "e8f70201-8899-3f0c-9e07-5d6495bc8046" || metadata.ReleaseGroup.PrimaryType != "Album" {
+		t.Errorf("Unexpected release group: %+v", metadata.ReleaseGroup)
+	}
+
+	if len(metadata.Media) != 1 {
+		t.Fatalf("len(Media) = %v, want 1", len(metadata.Media))
+	}
+
+	media := metadata.Media[0]
+	if media.Position != 1 || media.Format != "CD" || media.TrackCount != 12 {
+		t.Errorf("Unexpected media: position %v, format %q, track count %v", media.Position, media.Format, media.TrackCount)
+	}
+
+	if len(media.Tracks) != 12 {
+		t.Fatalf("len(Tracks) = %v, want 12", len(media.Tracks))
+	}
+
+	track := media.Tracks[7]
+	if track.Number != "8" || track.Position != 8 || track.Title != "Nothing Else Matters" || track.Length != 388293 {
+		t.Errorf("Unexpected track: %q %v %q %v", track.Number, track.Position, track.Title, track.Length)
+	}
+
+	if track.Recording.Length != 388293 {
+		t.Errorf("Recording.Length = %v, want 388293", track.Recording.Length)
+	}
+
+	if got := track.Credit().String(); got != "Metallica" {
+		t.Errorf("Credit = %q, want %q", got, "Metallica")
+	}
+
+	if got := metadata.ArtistCredit.String(); got != "Metallica" {
+		t.Errorf("ArtistCredit = %q, want %q", got, "Metallica")
+	}
+}
+
+func TestFetchAlbumMetadataCredits(t *testing.T) {
+	server := mbtest.NewServer()
+	defer server.Close()
+
+	server.AddRelease(splitReleaseId, splitRelease)
+
+	metadata, err := server.Client().FetchAlbumMetadata(splitReleaseId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := metadata.ArtistCredit.String(); got != "Artist A & B" {
+		t.Errorf("ArtistCredit = %q, want %q", got, "Artist A & B")
+	}
+
+	artists := metadata.ArtistCredit.Artists()
+	if len(artists) != 2 || artists[0].Name != "Artist A" || artists[1].Name != "Artist B" {
+		t.Errorf("Unexpected artists: %+v", artists)
+	}
+
+	if len(metadata.Media) != 2 {
+		t.Fatalf("len(Media) = %v, want 2", len(metadata.Media))
+	}
+
+	first := metadata.Media[0]
+	second := metadata.Media[1]
+
+	if first.Position != 1 || first.Format != "CD" {
+		t.Errorf("Unexpected first media: position %v, format %q", first.Position, first.Format)
+	}
+
+	if second.Position != 2 || second.Format != "Digital Media" {
+		t.Errorf("Unexpected second media: position %v, format %q", second.Position, second.Format)
+	}
+
+	// NOTE(patrik): The first track has no credit of its own so the
+	// recording credit is used
+	track := first.Tracks[0]
+	if got := track.Credit().String(); got != "Artist A" {
+		t.Errorf("Credit = %q, want %q", got, "Artist A")
+	}
+
+	if track.Length != 181000 || track.Recording.Length != 180500 {
+		t.Errorf("Unexpected lengths: %v %v", track.Length, track.Recording.Length)
+	}
+
+	// NOTE(patrik): An empty credited name falls back to the artist name
+	track = second.Tracks[0]
+	if got := track.Credit().String(); got != "Artist A feat. Artist C" {
+		t.Errorf("Credit = %q, want %q", got, "Artist A feat. Artist C")
+	}
+
+	if track.Credit()[0].JoinPhrase != " feat. " {
+		t.Errorf("JoinPhrase = %q, want %q", track.Credit()[0].JoinPhrase, " feat. ")
+	}
+
+	if track.Number != "A1" || track.Position != 1 || track.Length != 240000 {
+		t.Errorf("Unexpected track: %q %v %v", track.Number, track.Position, track.Length)
+	}
+}
+
+func TestLookupDiscId(t *testing.T) {
+	server := mbtest.NewServer()
+	defer server.Close()
+
+	releases, err := server.Client().LookupDiscId(mbtest.MetallicaDiscId, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(releases) != 1 || releases[0].Id != mbtest.MetallicaReleaseId {
+		t.Fatalf("Unexpected releases: %+v", releases)
+	}
+
+	_, err = server.Client().LookupDiscId("unknown-disc-id", "")
+	if !errors.Is(err, musicbrainz.ErrNotFound) {
+		t.Fatalf("err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestLookupDiscIdCache(t *testing.T) {
+	server := mbtest.NewServer()
+	defer server.Close()
+
+	client := server.Client(musicbrainz.WithCache(t.TempDir(), time.Hour))
+
+	// NOTE(patrik): A lookup with a toc can return other releases then
+	// the exact lookup, so both are fetched once and then cached apart
+	lookups := []struct {
+		toc      string
+		requests int
+	}{
+		{"", 1},
+		{"1 12 242457 150", 2},
+		{"1 12 242457 182", 3},
+		{"", 3},
+		{"1 12 242457 150", 3},
+	}
+
+	for _, lookup := range lookups {
+		_, err := client.LookupDiscId(mbtest.MetallicaDiscId, lookup.toc)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if server.Requests() != lookup.requests {
+			t.Errorf("Requests after toc %q = %v, want %v", lookup.toc, server.Requests(), lookup.requests)
+		}
+	}
+}
+
+func TestFetchCoverArt(t *testing.T) {
+	server := mbtest.NewServer()
+	defer server.Close()
+
+	data := []byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00")
+	server.AddCover(splitReleaseId, "image/jpeg", data)
+
+	cover, err := server.Client().FetchCoverArt(mbtest.MetallicaReleaseId, musicbrainz.CoverSize500)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cover.Ext != "png" || len(cover.Data) == 0 {
+		t.Errorf("Unexpected cover: %q with %v bytes", cover.Ext, len(cover.Data))
+	}
+
+	cover, err = server.Client().FetchCoverArt(splitReleaseId, musicbrainz.CoverSizeOriginal)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cover.Ext != "jpg" || !bytes.Equal(cover.Data, data) {
+		t.Errorf("Unexpected cover: %q with %v bytes", cover.Ext, len(cover.Data))
+	}
+}
+
+func TestFetchAlbumMetadataNotFound(t *testing.T) {
+	server := mbtest.NewServer()
+	defer server.Close()
+
+	_, err := server.Client().FetchAlbumMetadata(splitReleaseId)
+	if !errors.Is(err, musicbrainz.ErrNotFound) {
+		t.Fatalf("err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestFetchCoverArtReleaseGroup(t *testing.T) {
+	server := mbtest.NewServer()
+	defer server.Close()
+
+	// NOTE(patrik): Only the release group has a cover
+	data := []byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00")
+	server.AddRelease(splitReleaseId, splitRelease)
+	server.AddCover(splitReleaseGroupId, "image/jpeg", data)
+
+	cover, err := server.Client().FetchCoverArt(splitReleaseId, musicbrainz.CoverSize1200)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cover.Ext != "jpg" || !bytes.Equal(cover.Data, data) {
+		t.Errorf("Unexpected cover: %q with %v bytes", cover.Ext, len(cover.Data))
+	}
+}
+
+func TestFetchCoverArtNotFound(t *testing.T) {
+	server := mbtest.NewServer()
+	defer server.Close()
+
+	server.AddRelease(splitReleaseId, splitRelease)
+
+	_, err := server.Client().FetchCoverArt(splitReleaseId, musicbrainz.CoverSizeOriginal)
+	if !errors.Is(err, musicbrainz.ErrNotFound) {
+		t.Fatalf("err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestSearchReleases(t *testing.T) {
+	server := mbtest.NewServer()
+	defer server.Close()
+
+	releases, err := server.Client().SearchReleases("Metallica", "Metallica", 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(releases) != 1 {
+		t.Fatalf("len(releases) = %v, want 1", len(releases))
+	}
+
+	release := releases[0]
+	if release.Id != mbtest.MetallicaReleaseId || release.Score != 100 || release.TrackCount != 12 {
+		t.Errorf("Unexpected release: %q score %v with %v tracks", release.Id, release.Score, release.TrackCount)
+	}
+
+	if len(release.Media) != 1 || release.Media[0].Format != "CD" {
+		t.Errorf("Unexpected media: %+v", release.Media)
+	}
+
+	server.SetSearch([]byte(`{"count": 2, "releases": [
+		{"id": "a", "score": 90, "title": "First", "artist-credit": [{"name": "Artist"}]},
+		{"id": "b", "score": 45, "title": "Second"}
+	]}`))
+
+	releases, err = server.Client().SearchReleases("First", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(releases) != 2 || releases[0].Id != "a" || releases[1].Score != 45 {
+		t.Fatalf("Unexpected releases: %+v", releases)
+	}
+
+	if len(releases[0].ArtistCredit) != 1 || releases[0].ArtistCredit[0].Name != "Artist" {
+		t.Errorf("Unexpected artist credit: %+v", releases[0].ArtistCredit)
+	}
+}
+
+func TestSearchReleasesEmpty(t *testing.T) {
+	server := mbtest.NewServer()
+	defer server.Close()
+
+	_, err := server.Client().SearchReleases("", "", 0)
+	if err == nil {
+		t.Fatal("Expected an error")
+	}
+
+	if server.Requests() != 0 {
+		t.Errorf("Requests = %v, want 0", server.Requests())
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	server := mbtest.NewServer()
+	defer server.Close()
+
+	server.SetUnavailable(1, "1")
+
+	start := time.Now()
+	metadata, err := server.Client().FetchAlbumMetadata(mbtest.MetallicaReleaseId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if metadata.Id != mbtest.MetallicaReleaseId {
+		t.Errorf("Id = %q, want %q", metadata.Id, mbtest.MetallicaReleaseId)
+	}
+
+	if server.Requests() != 2 {
+		t.Errorf("Requests = %v, want 2", server.Requests())
+	}
+
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("Retried after %v, want at least 1s", elapsed)
+	}
+}
+
+func TestFetchAlbumMetadataCache(t *testing.T) {
+	server := mbtest.NewServer()
+	defer server.Close()
+
+	dir := t.TempDir()
+	client := server.Client(musicbrainz.WithCache(dir, time.Hour))
+
+	for i := 0; i < 2; i++ {
+		_, err := client.FetchAlbumMetadata(mbtest.MetallicaReleaseId)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if server.Requests() != 1 {
+		t.Errorf("Requests = %v, want 1", server.Requests())
+	}
+
+	// NOTE(patrik): Age every cached entry past the ttl
+	old := time.Now().Add(-2 * time.Hour)
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		return os.Chtimes(p, old, old)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.FetchAlbumMetadata(mbtest.MetallicaReleaseId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if server.Requests() != 2 {
+		t.Errorf("Requests after the ttl = %v, want 2", server.Requests())
+	}
+}
+
+func TestRetryAfterHonored(t *testing.T) {
+	server := mbtest.NewServer()
+	defer server.Close()
+
+	// NOTE(patrik): Longer then the 1s the client waits when the server
+	// doesn't say how long to wait
+	server.SetUnavailable(1, "2")
+
+	start := time.Now()
+	_, err := server.Client().FetchAlbumMetadata(mbtest.MetallicaReleaseId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if server.Requests() != 2 {
+		t.Errorf("Requests = %v, want 2", server.Requests())
+	}
+
+	if elapsed := time.Since(start); elapsed < 2*time.Second {
+		t.Errorf("Retried after %v, want at least 2s", elapsed)
+	}
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/nanoteck137/dwebble-importer/coverart"
+	"github.com/nanoteck137/dwebble-importer/server"
+	"github.com/nanoteck137/dwebble-importer/utils"
+)
+
+const (
+	ActionExisting = "existing"
+	ActionCreate   = "create"
+)
+
+type PlanArtist struct {
+	Name   string `json:"name"`
+	Mbid   string `json:"mbid,omitempty"`
+	Id     string `json:"id,omitempty"`
+	Action string `json:"action"`
+}
+
+// NOTE(patrik): Artist is the credit as displayed, Artists are the names
+// of every credited artist with the primary artist first
+type PlanAlbum struct {
+	Name    string   `json:"name"`
+	Artist  string   `json:"artist"`
+	Artists []string `json:"artists"`
+	Id      string   `json:"id,omitempty"`
+	Action  string   `json:"action"`
+
+	Mbid             string `json:"mbid,omitempty"`
+	ReleaseGroupMbid string `json:"releaseGroupMbid,omitempty"`
+
+	// NOTE(patrik): CoverFile is the cover image found in the album dir
+	// and Cover the embedded cover. CoverArt is the file uploaded as the
+	// cover, it's filled in when the import runs
+	CoverFile string     `json:"coverFile,omitempty"`
+	Cover     *PlanCover `json:"cover,omitempty"`
+	CoverArt  string     `json:"coverArt,omitempty"`
+}
+
+// NOTE(patrik): Number is the number on the disc and AlbumNumber the
+// album wide number the track is created with on the server
+type PlanTrack struct {
+	Name        string `json:"name"`
+	Number      int    `json:"number"`
+	Disc        int    `json:"disc"`
+	AlbumNumber int    `json:"albumNumber"`
+	Artist      string `json:"artist"`
+	SourceFile  string `json:"sourceFile"`
+
+	Artists       []string `json:"artists"`
+	RecordingMbid string   `json:"recordingMbid,omitempty"`
+
+	Audio    utils.AudioStream `json:"audio"`
+	Duration float64           `json:"duration"`
+
+	Cover    *PlanCover `json:"cover,omitempty"`
+	CoverArt string     `json:"coverArt,omitempty"`
+
+	BestQualityFile   string   `json:"bestQualityFile"`
+	BestQualityArgs   []string `json:"bestQualityArgs"`
+	MobileQualityFile string   `json:"mobileQualityFile"`
+	MobileQualityArgs []string `json:"mobileQualityArgs"`
+}
+
+// Plan describes everything an import of an album directory is going to
+// do, it's built using only read requests so it's safe to compute
+// without touching the server
+type Plan struct {
+	Dir     string       `json:"dir"`
+	WorkDir string       `json:"workDir"`
+	Artists []PlanArtist `json:"artists"`
+	Album   PlanAlbum    `json:"album"`
+	Tracks  []PlanTrack  `json:"tracks"`
+
+	DurationMismatches []DurationMismatch `json:"durationMismatches,omitempty"`
+}
+
+// NOTE(patrik): Lossy files at or below this bitrate are used as the
+// mobile quality file as is
+const mobileBitRate = 192000
+
+// bestQualityExt picks the format of the best quality file and the
+// codec it's encoded with, "copy" when the source is kept as is. Lossy
+// sources are kept in their own codec since encoding them again would
+// only lose quality.
+//
+// NOTE(patrik): The server only accepts flac, ogg and mpeg files, other
+// codecs (e.g. aac or alac) are encoded as flac when they are lossless
+// and as mp3 otherwise
+func bestQualityExt(audio *utils.AudioStream) (string, string) {
+	switch audio.Codec {
+	case "flac":
+		return "flac", "copy"
+	case "mp3":
+		return "mp3", "copy"
+	case "opus":
+		return "opus", "copy"
+	case "vorbis":
+		return "ogg", "copy"
+	}
+
+	if audio.Lossless() {
+		return "flac", "flac"
+	}
+
+	return "mp3", "libmp3lame"
+}
+
+func bestQualityArgs(input, output string, audio *utils.AudioStream) []string {
+	args := []string{"-y", "-i", input, "-map_metadata", "-1", "-map", "0", "-map", "-0:v"}
+
+	_, codec := bestQualityExt(audio)
+	args = append(args, "-c:a", codec)
+	if codec == "libmp3lame" {
+		args = append(args, "-b:a", "320k")
+	}
+
+	return append(args, output)
+}
+
+func mobileQualityArgs(input, output string, audio *utils.AudioStream) []string {
+	if audio.Codec == "mp3" && audio.BitRate > 0 && audio.BitRate <= mobileBitRate {
+		return []string{"-y", "-i", input, "-map", "0:a", "-c:a", "copy", output}
+	}
+
+	// ffmpeg -i input.flac -ab 320k -map_metadata 0 -id3v2_version 3 output.mp3
+	return []string{"-y", "-i", input, "-ab", "192k", output}
+}
+
+func (plan *Plan) Artist(name string) *PlanArtist {
+	for i := range plan.Artists {
+		if plan.Artists[i].Name == name {
+			return &plan.Artists[i]
+		}
+	}
+
+	return nil
+}
+
+// matchMbid picks which of the entries the server found by name is the
+// one with the MusicBrainz id mbid. An entry with the same id always
+// wins, an entry without an id is only used when it's the only one.
+// Returns -1 when nothing matches and ambiguous when more then one could
+func matchMbid(mbids []string, mbid string) (index int, ambiguous bool) {
+	if mbid != "" {
+		for i, m := range mbids {
+			if m == mbid {
+				return i, false
+			}
+		}
+	}
+
+	index = -1
+	for i, m := range mbids {
+		// NOTE(patrik): An entry with another id is a different artist or
+		// album that happens to have the same name
+		if mbid != "" && m != "" {
+			continue
+		}
+
+		if index != -1 {
+			return -1, true
+		}
+
+		index = i
+	}
+
+	return index, false
+}
+
+func planArtist(ctx context.Context, api *server.Server, name, mbid string) (PlanArtist, error) {
+	res, err := api.GetArtists(ctx, name)
+	if err != nil {
+		return PlanArtist{}, err
+	}
+
+	mbids := make([]string, 0, len(res.Artists))
+	for _, artist := range res.Artists {
+		mbids = append(mbids, artist.Mbid)
+	}
+
+	index, ambiguous := matchMbid(mbids, mbid)
+	if ambiguous {
+		return PlanArtist{}, fmt.Errorf("Server returned more then one artist for name '%s'", name)
+	}
+
+	if index == -1 {
+		return PlanArtist{
+			Name:   name,
+			Mbid:   mbid,
+			Action: ActionCreate,
+		}, nil
+	}
+
+	return PlanArtist{
+		Name:   name,
+		Mbid:   mbid,
+		Id:     res.Artists[index].Id,
+		Action: ActionExisting,
+	}, nil
+}
+
+func planAlbum(ctx context.Context, api *server.Server, config *Config, artist *PlanArtist) (PlanAlbum, error) {
+	album := PlanAlbum{
+		Name:    config.Name,
+		Artist:  config.Artist,
+		Artists: config.AlbumArtists(),
+		Action:  ActionCreate,
+
+		Mbid:             config.ReleaseMbid,
+		ReleaseGroupMbid: config.ReleaseGroupMbid,
+	}
+
+	// NOTE(patrik): A new artist can't have any albums yet
+	if artist.Action == ActionCreate {
+		return album, nil
+	}
+
+	albums, err := api.GetArtistAlbums(ctx, artist.Id, config.Name)
+	if err != nil {
+		return PlanAlbum{}, err
+	}
+
+	mbids := make([]string, 0, len(albums.Albums))
+	for _, album := range albums.Albums {
+		mbids = append(mbids, album.Mbid)
+	}
+
+	index, ambiguous := matchMbid(mbids, config.ReleaseMbid)
+	if ambiguous {
+		return PlanAlbum{}, fmt.Errorf("Server returned more then one album for '%v' - '%v'", config.Artist, config.Name)
+	}
+
+	if index != -1 {
+		album.Id = albums.Albums[index].Id
+		album.Action = ActionExisting
+	}
+
+	return album, nil
+}
+
+func buildPlan(ctx context.Context, api *server.Server, dir, workDir string, coverNames []string) (*Plan, error) {
+	config, err := readConfig(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{
+		Dir:     dir,
+		WorkDir: workDir,
+	}
+
+	names := config.AlbumArtists()
+	for i := range config.Tracks {
+		names = append(names, config.TrackArtists(&config.Tracks[i])...)
+	}
+
+	mbids := config.ArtistMbidMap()
+
+	for _, name := range names {
+		if name == "" || plan.Artist(name) != nil {
+			continue
+		}
+
+		artist, err := planArtist(ctx, api, name, mbids[name])
+		if err != nil {
+			return nil, err
+		}
+
+		plan.Artists = append(plan.Artists, artist)
+	}
+
+	albumArtists := config.AlbumArtists()
+	if len(albumArtists) == 0 {
+		return nil, fmt.Errorf("Album has no artist")
+	}
+
+	albumArtist := plan.Artist(albumArtists[0])
+	if albumArtist == nil {
+		return nil, fmt.Errorf("Album has no artist")
+	}
+
+	plan.Album, err = planAlbum(ctx, api, &config, albumArtist)
+	if err != nil {
+		return nil, err
+	}
+
+	plan.Album.CoverFile = coverart.Find(dir, coverNames)
+
+	for i := range config.Tracks {
+		track := &config.Tracks[i]
+
+		artist := config.Artist
+		if track.Artist != "" {
+			artist = track.Artist
+		}
+
+		artists := config.TrackArtists(track)
+		if len(artists) == 0 {
+			return nil, fmt.Errorf("Track '%v' has no artist", track.Name)
+		}
+
+		disc := track.DiscNumber()
+		sourceFile := path.Join(dir, track.Filename)
+
+		probe, err := utils.ProbeFile(sourceFile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to probe '%v': %w", sourceFile, err)
+		}
+
+		audio, ok := probe.Audio()
+		if !ok {
+			return nil, fmt.Errorf("No audio stream in '%v'", sourceFile)
+		}
+
+		var cover *PlanCover
+		if picture, ok := probe.Cover(); ok {
+			cover = &PlanCover{
+				SourceFile: sourceFile,
+				Picture:    picture,
+			}
+
+			if plan.Album.Cover == nil {
+				plan.Album.Cover = cover
+			}
+		}
+
+		bestQualityExt, _ := bestQualityExt(&audio)
+		bestQualityFile := path.Join(workDir, fmt.Sprintf("%v-%v.best.%v", disc, track.Num, bestQualityExt))
+		mobileQualityFile := path.Join(workDir, fmt.Sprintf("%v-%v.mobile.mp3", disc, track.Num))
+
+		plan.Tracks = append(plan.Tracks, PlanTrack{
+			Name:              track.Name,
+			Number:            track.Num,
+			Disc:              disc,
+			AlbumNumber:       config.AlbumTrackNumber(track),
+			Artist:            artist,
+			Artists:           artists,
+			RecordingMbid:     track.RecordingMbid,
+			SourceFile:        sourceFile,
+			Audio:             audio,
+			Duration:          probe.Duration,
+			Cover:             cover,
+			BestQualityFile:   bestQualityFile,
+			BestQualityArgs:   bestQualityArgs(sourceFile, bestQualityFile, &audio),
+			MobileQualityFile: mobileQualityFile,
+			MobileQualityArgs: mobileQualityArgs(sourceFile, mobileQualityFile, &audio),
+		})
+	}
+
+	return plan, nil
+}
+
+func (plan *Plan) Print() {
+	fmt.Printf("Plan for '%v'\n", plan.Dir)
+
+	fmt.Printf("  Artists:\n")
+	for _, artist := range plan.Artists {
+		if artist.Action == ActionExisting {
+			fmt.Printf("    [%v] %v (%v)\n", artist.Action, artist.Name, artist.Id)
+		} else {
+			fmt.Printf("    [%v] %v\n", artist.Action, artist.Name)
+		}
+	}
+
+	fmt.Printf("  Album:\n")
+	if plan.Album.Action == ActionExisting {
+		fmt.Printf("    [%v] %v - %v (%v)\n", plan.Album.Action, plan.Album.Artist, plan.Album.Name, plan.Album.Id)
+	} else {
+		fmt.Printf("    [%v] %v - %v\n", plan.Album.Action, plan.Album.Artist, plan.Album.Name)
+	}
+
+	if plan.Album.CoverFile != "" {
+		fmt.Printf("      cover: %v\n", plan.Album.CoverFile)
+	} else if plan.Album.Cover != nil {
+		fmt.Printf("      cover: %v (embedded, %v)\n", plan.Album.Cover.SourceFile, plan.Album.Cover.Picture.String())
+	} else {
+		fmt.Printf("      cover: none, a placeholder is used\n")
+	}
+
+	fmt.Printf("  Tracks:\n")
+	for _, track := range plan.Tracks {
+		fmt.Printf("    %v-%v %v - %v\n", track.Disc, track.Number, track.Artist, track.Name)
+		if track.AlbumNumber != track.Number {
+			fmt.Printf("      number on the server: %v\n", track.AlbumNumber)
+		}
+		fmt.Printf("      source: %v (%v, %v)\n", track.SourceFile, track.Audio.String(), formatLength(track.Duration))
+		if track.Cover != nil {
+			fmt.Printf("      cover: embedded, %v\n", track.Cover.Picture.String())
+		}
+		fmt.Printf("      ffmpeg %v\n", formatArgs(track.BestQualityArgs))
+		fmt.Printf("      ffmpeg %v\n", formatArgs(track.MobileQualityArgs))
+	}
+
+	if len(plan.DurationMismatches) > 0 {
+		fmt.Printf("  Duration mismatches:\n")
+		for i := range plan.DurationMismatches {
+			fmt.Printf("    %v\n", plan.DurationMismatches[i].String())
+		}
+	}
+}
+
+func formatArgs(args []string) string {
+	quoted := make([]string, 0, len(args))
+	for _, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t'\"") {
+			arg = fmt.Sprintf("%q", arg)
+		}
+
+		quoted = append(quoted, arg)
+	}
+
+	return strings.Join(quoted, " ")
+}
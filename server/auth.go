@@ -0,0 +1,125 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+)
+
+type auth struct {
+	mu sync.Mutex
+
+	token    string
+	username string
+	password string
+}
+
+// WithToken authenticates every request with a bearer API token
+func WithToken(token string) Option {
+	return func(server *Server) {
+		server.auth.token = token
+	}
+}
+
+// WithLogin authenticates using a username and password, the client
+// signs in on the first request and signs in again if the token stops
+// being accepted
+func WithLogin(username, password string) Option {
+	return func(server *Server) {
+		server.auth.username = username
+		server.auth.password = password
+	}
+}
+
+func (auth *auth) canLogin() bool {
+	return auth.username != ""
+}
+
+type signinBody struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type signinData struct {
+	Token string `json:"token"`
+}
+
+type signinResponse struct {
+	Status int        `json:"status"`
+	Data   signinData `json:"data"`
+}
+
+// Login signs in with the configured username and password and stores
+// the returned token
+func (server *Server) Login(ctx context.Context) error {
+	if !server.auth.canLogin() {
+		return errors.New("No username set")
+	}
+
+	body, err := json.Marshal(signinBody{
+		Username: server.auth.username,
+		Password: server.auth.password,
+	})
+	if err != nil {
+		return err
+	}
+
+	endpoint := "/auth/signin"
+	req, err := server.newRawReq(ctx, "POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	data, _, err := server.do(req, endpoint)
+	if err != nil {
+		return err
+	}
+
+	var response signinResponse
+	if err := json.Unmarshal(data, &response); err != nil {
+		return err
+	}
+
+	if response.Data.Token == "" {
+		return errors.New("Server didn't return a token")
+	}
+
+	server.auth.mu.Lock()
+	server.auth.token = response.Data.Token
+	server.auth.mu.Unlock()
+
+	return nil
+}
+
+// authToken returns the token to send with a request, signing in first
+// if needed
+func (server *Server) authToken(ctx context.Context) (string, error) {
+	server.auth.mu.Lock()
+	token := server.auth.token
+	server.auth.mu.Unlock()
+
+	if token != "" || !server.auth.canLogin() {
+		return token, nil
+	}
+
+	if err := server.Login(ctx); err != nil {
+		return "", err
+	}
+
+	server.auth.mu.Lock()
+	defer server.auth.mu.Unlock()
+	return server.auth.token, nil
+}
+
+// refreshToken throws away the current token and signs in again
+func (server *Server) refreshToken(ctx context.Context) error {
+	server.auth.mu.Lock()
+	server.auth.token = ""
+	server.auth.mu.Unlock()
+
+	return server.Login(ctx)
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// authServer hands out a new token for every sign in, only the token
+// from the latest sign in is accepted and every request is rejected
+// when reject is set
+type authServer struct {
+	logins   atomic.Int32
+	requests atomic.Int32
+	reject   bool
+}
+
+func (s *authServer) handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/auth/signin" {
+			var body signinBody
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Username != "patrik" || body.Password != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			n := s.logins.Add(1)
+			fmt.Fprintf(w, `{"status":200,"data":{"token":"tok%v"}}`, n)
+			return
+		}
+
+		s.requests.Add(1)
+
+		// NOTE(patrik): The first token is treated as expired
+		want := fmt.Sprintf("Bearer tok%v", s.logins.Load())
+		if s.reject || s.logins.Load() < 2 || r.Header.Get("Authorization") != want {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"status":401,"message":"Unauthorized"}`)
+			return
+		}
+
+		fmt.Fprint(w, `{"status":200,"data":{"artists":[],"id":"a1"}}`)
+	}
+}
+
+func TestRelogin(t *testing.T) {
+	s := &authServer{}
+	ts := newTestServer(t, s.handler())
+
+	api := New(ts.URL, WithLogin("patrik", "secret"), WithRetry(3, time.Millisecond))
+
+	_, err := api.GetArtists(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := s.logins.Load(); n != 2 {
+		t.Errorf("Signed in %v times, want 2", n)
+	}
+
+	if n := s.requests.Load(); n != 2 {
+		t.Errorf("Server got %v requests, want 2", n)
+	}
+
+	// NOTE(patrik): The new token is kept for the next request
+	_, err = api.GetArtists(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := s.logins.Load(); n != 2 {
+		t.Errorf("Signed in %v times, want 2", n)
+	}
+}
+
+func TestReloginPost(t *testing.T) {
+	s := &authServer{}
+	ts := newTestServer(t, s.handler())
+
+	api := New(ts.URL, WithLogin("patrik", "secret"), WithRetry(3, time.Millisecond))
+
+	res, err := api.CreateAlbum(context.Background(), AlbumData{
+		Name:     "Metallica",
+		ArtistId: "a1",
+		CoverArt: testFile(t, "cover"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Id != "a1" {
+		t.Errorf("Id = %v, want a1", res.Id)
+	}
+
+	if n := s.requests.Load(); n != 2 {
+		t.Errorf("Server got %v requests, want 2", n)
+	}
+}
+
+func TestReloginOnce(t *testing.T) {
+	s := &authServer{reject: true}
+	ts := newTestServer(t, s.handler())
+
+	api := New(ts.URL, WithLogin("patrik", "secret"), WithRetry(3, time.Millisecond))
+
+	_, err := api.GetArtists(context.Background())
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("err = %v, want %v", err, ErrUnauthorized)
+	}
+
+	if n := s.logins.Load(); n != 2 {
+		t.Errorf("Signed in %v times, want 2", n)
+	}
+
+	if n := s.requests.Load(); n != 2 {
+		t.Errorf("Server got %v requests, want 2", n)
+	}
+}
+
+func TestTokenNoRelogin(t *testing.T) {
+	s := &authServer{}
+	ts := newTestServer(t, s.handler())
+
+	api := New(ts.URL, WithToken("tok1"), WithRetry(3, time.Millisecond))
+
+	_, err := api.GetArtists(context.Background())
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("err = %v, want %v", err, ErrUnauthorized)
+	}
+
+	if n := s.logins.Load(); n != 0 {
+		t.Errorf("Signed in %v times, want 0", n)
+	}
+
+	if n := s.requests.Load(); n != 1 {
+		t.Errorf("Server got %v requests, want 1", n)
+	}
+}
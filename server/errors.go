@@ -0,0 +1,130 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrBadRequest   = errors.New("bad request")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+	ErrNotFound     = errors.New("not found")
+	ErrConflict     = errors.New("conflict")
+	ErrServer       = errors.New("server error")
+)
+
+// ApiError is returned for every response from the dwebble server that
+// isn't successful, use errors.Is with the Err* sentinels to check what
+// kind of error it is
+type ApiError struct {
+	Method     string
+	Endpoint   string
+	StatusCode int
+
+	// NOTE(patrik): Decoded from the dwebble error payload, Body is set
+	// to the raw response when the payload couldn't be decoded
+	Code        int
+	Message     string
+	FieldErrors map[string]string
+	Data        any
+	Body        string
+}
+
+func (err *ApiError) Error() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%v %v: %v", err.Method, err.Endpoint, err.StatusCode)
+
+	if err.Message != "" {
+		fmt.Fprintf(&b, " %v", err.Message)
+	} else if err.Body != "" {
+		fmt.Fprintf(&b, " %v", err.Body)
+	}
+
+	if len(err.FieldErrors) > 0 {
+		fields := make([]string, 0, len(err.FieldErrors))
+		for field := range err.FieldErrors {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+
+		for _, field := range fields {
+			fmt.Fprintf(&b, " (%v: %v)", field, err.FieldErrors[field])
+		}
+	}
+
+	return b.String()
+}
+
+func (err *ApiError) Is(target error) bool {
+	switch target {
+	case ErrBadRequest:
+		return err.StatusCode == http.StatusBadRequest
+	case ErrUnauthorized:
+		return err.StatusCode == http.StatusUnauthorized
+	case ErrForbidden:
+		return err.StatusCode == http.StatusForbidden
+	case ErrNotFound:
+		return err.StatusCode == http.StatusNotFound
+	case ErrConflict:
+		return err.StatusCode == http.StatusConflict || err.isUniqueViolation()
+	case ErrServer:
+		return err.StatusCode >= 500
+	}
+
+	return false
+}
+
+// isUniqueViolation reports if the error is the server rejecting a
+// duplicate.
+//
+// NOTE(patrik): dwebble doesn't use 409, a unique constraint violation
+// comes back as a 400 (e.g. "Failed to create track: Number need to be
+// unique")
+func (err *ApiError) isUniqueViolation() bool {
+	if err.StatusCode != http.StatusBadRequest {
+		return false
+	}
+
+	return strings.Contains(strings.ToLower(err.Message), "unique")
+}
+
+type errorPayload struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+	Data    any    `json:"data"`
+}
+
+func newApiError(method, endpoint string, statusCode int, body []byte) *ApiError {
+	apiErr := &ApiError{
+		Method:     method,
+		Endpoint:   endpoint,
+		StatusCode: statusCode,
+	}
+
+	var payload errorPayload
+	if err := json.Unmarshal(body, &payload); err != nil || payload.Message == "" {
+		apiErr.Body = strings.TrimSpace(string(body))
+		return apiErr
+	}
+
+	apiErr.Code = payload.Status
+	apiErr.Message = payload.Message
+	apiErr.Data = payload.Data
+
+	// NOTE(patrik): Validation errors are sent as a map of field name to
+	// error message
+	if fields, ok := payload.Data.(map[string]any); ok {
+		apiErr.FieldErrors = make(map[string]string)
+		for field, value := range fields {
+			apiErr.FieldErrors[field] = fmt.Sprint(value)
+		}
+	}
+
+	return apiErr
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestApiErrorIs(t *testing.T) {
+	tests := []struct {
+		status int
+		body   string
+		target error
+		want   bool
+	}{
+		{409, `{"status":409,"message":"Artist already exists"}`, ErrConflict, true},
+		// NOTE(patrik): What dwebble sends when the track number is taken
+		{400, `{"status":400,"message":"Failed to create track: Number need to be unique"}`, ErrConflict, true},
+		{400, `{"status":400,"message":"Failed to create track: Number need to be unique"}`, ErrBadRequest, true},
+		{400, `{"status":400,"message":"No artist with id: 'abc'"}`, ErrConflict, false},
+		{400, `unique`, ErrConflict, false},
+		{500, `{"status":500,"message":"Unique constraint"}`, ErrConflict, false},
+		{500, `{"status":500,"message":"Internal Server Error"}`, ErrServer, true},
+		{404, `{"status":404,"message":"Not Found"}`, ErrNotFound, true},
+		{401, `{"status":401,"message":"Unauthorized"}`, ErrUnauthorized, true},
+	}
+
+	for _, test := range tests {
+		err := newApiError("POST", "/tracks", test.status, []byte(test.body))
+		if got := errors.Is(err, test.target); got != test.want {
+			t.Errorf("errors.Is(%v, %v) = %v, want %v", err, test.target, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime/multipart"
+	"net/textproto"
+)
+
+type formField struct {
+	name  string
+	value string
+	file  *File
+}
+
+func textField(name, value string) formField {
+	return formField{name: name, value: value}
+}
+
+func fileField(name string, file *File) formField {
+	return formField{name: name, file: file}
+}
+
+// Form is a multipart form that is streamed to the server instead of
+// being buffered in memory
+type Form struct {
+	fields   []formField
+	boundary string
+
+	// NOTE(patrik): Start offsets of the file contents, used to send the
+	// form again when a request is retried
+	offsets []int64
+}
+
+func newForm(fields ...formField) *Form {
+	// NOTE(patrik): Only used to get a random boundary
+	w := multipart.NewWriter(io.Discard)
+
+	return &Form{
+		fields:   fields,
+		boundary: w.Boundary(),
+	}
+}
+
+func (form *Form) add(fields ...formField) {
+	form.fields = append(form.fields, fields...)
+}
+
+// mark records the current position of every file so the form can be
+// rewound later, files that can't seek are marked with -1
+func (form *Form) mark() {
+	form.offsets = make([]int64, len(form.fields))
+
+	for i, field := range form.fields {
+		form.offsets[i] = -1
+
+		if field.file == nil {
+			continue
+		}
+
+		seeker, ok := field.file.Content.(io.Seeker)
+		if !ok {
+			continue
+		}
+
+		offset, err := seeker.Seek(0, io.SeekCurrent)
+		if err != nil {
+			continue
+		}
+
+		form.offsets[i] = offset
+	}
+}
+
+func (form *Form) canRewind() bool {
+	if form.offsets == nil {
+		return false
+	}
+
+	for i, field := range form.fields {
+		if field.file != nil && form.offsets[i] < 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// rewind moves every file back to the position recorded by mark
+func (form *Form) rewind() error {
+	if !form.canRewind() {
+		return errors.New("form can't be rewound")
+	}
+
+	for i, field := range form.fields {
+		if field.file == nil {
+			continue
+		}
+
+		seeker := field.file.Content.(io.Seeker)
+		if _, err := seeker.Seek(form.offsets[i], io.SeekStart); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (form *Form) ContentType() string {
+	return "multipart/form-data; boundary=" + form.boundary
+}
+
+func fileHeader(fieldName string, file *File) textproto.MIMEHeader {
+	h := make(textproto.MIMEHeader)
+	dis := fmt.Sprintf(`form-data; name="%s"; filename="%s"`, fieldName, file.Name)
+	h.Set("Content-Disposition", dis)
+	h.Set("Content-Type", file.ContentType)
+	return h
+}
+
+// contentSize returns the size of r if it can be known without reading
+// it, otherwise -1
+func contentSize(r io.Reader) int64 {
+	switch r := r.(type) {
+	case interface{ Stat() (fs.FileInfo, error) }:
+		info, err := r.Stat()
+		if err != nil || !info.Mode().IsRegular() {
+			return -1
+		}
+
+		// NOTE(patrik): The file might not be at the start
+		if seeker, ok := r.(io.Seeker); ok {
+			offset, err := seeker.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return -1
+			}
+
+			return info.Size() - offset
+		}
+
+		return info.Size()
+	case interface{ Len() int }:
+		return int64(r.Len())
+	}
+
+	return -1
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// ContentLength calculates the size of the encoded form, if the size of
+// any of the files is unknown -1 is returned and the form needs to be
+// sent chunked
+func (form *Form) ContentLength() int64 {
+	var counter countingWriter
+
+	w := multipart.NewWriter(&counter)
+	if err := w.SetBoundary(form.boundary); err != nil {
+		return -1
+	}
+
+	var size int64
+
+	for _, field := range form.fields {
+		if field.file == nil {
+			if err := w.WriteField(field.name, field.value); err != nil {
+				return -1
+			}
+
+			continue
+		}
+
+		if _, err := w.CreatePart(fileHeader(field.name, field.file)); err != nil {
+			return -1
+		}
+
+		n := contentSize(field.file.Content)
+		if n < 0 {
+			return -1
+		}
+
+		size += n
+	}
+
+	if err := w.Close(); err != nil {
+		return -1
+	}
+
+	return counter.n + size
+}
+
+// WriteTo encodes the form into dst, file contents are copied in
+// chunks so they are never fully held in memory
+func (form *Form) WriteTo(dst io.Writer) (int64, error) {
+	var counter countingWriter
+
+	w := multipart.NewWriter(io.MultiWriter(dst, &counter))
+	if err := w.SetBoundary(form.boundary); err != nil {
+		return counter.n, err
+	}
+
+	for _, field := range form.fields {
+		if field.file == nil {
+			if err := w.WriteField(field.name, field.value); err != nil {
+				return counter.n, err
+			}
+
+			continue
+		}
+
+		part, err := w.CreatePart(fileHeader(field.name, field.file))
+		if err != nil {
+			return counter.n, err
+		}
+
+		if _, err := io.Copy(part, field.file.Content); err != nil {
+			return counter.n, err
+		}
+	}
+
+	err := w.Close()
+	return counter.n, err
+}
+
+type formReader struct {
+	*io.PipeReader
+	done chan struct{}
+}
+
+// Close closes the pipe and waits for the writing goroutine to exit so
+// the files are not read from after Close returns
+func (r *formReader) Close() error {
+	err := r.PipeReader.Close()
+	<-r.done
+	return err
+}
+
+// Reader returns a reader streaming the encoded form, the form is
+// written from a separate goroutine through an io.Pipe
+func (form *Form) Reader() io.ReadCloser {
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		_, err := form.WriteTo(pw)
+		pw.CloseWithError(err)
+	}()
+
+	return &formReader{
+		PipeReader: pr,
+		done:       done,
+	}
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// gatedReader returns first and then blocks until gate is closed before
+// returning rest, if the form was buffered before being sent the server
+// would never see first
+type gatedReader struct {
+	first []byte
+	rest  []byte
+	gate  chan struct{}
+}
+
+func (r *gatedReader) Read(p []byte) (int, error) {
+	if len(r.first) > 0 {
+		n := copy(p, r.first)
+		r.first = r.first[n:]
+		return n, nil
+	}
+
+	if len(r.rest) == 0 {
+		return 0, io.EOF
+	}
+
+	select {
+	case <-r.gate:
+	case <-time.After(5 * time.Second):
+		return 0, errors.New("Timed out waiting for the server to read the start of the file")
+	}
+
+	n := copy(p, r.rest)
+	r.rest = r.rest[n:]
+	return n, nil
+}
+
+func TestFormStreamed(t *testing.T) {
+	first := bytes.Repeat([]byte("a"), 64*1024)
+	rest := bytes.Repeat([]byte("b"), 64*1024)
+	gate := make(chan struct{})
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mr, err := r.MultipartReader()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		fields := make(map[string]string)
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			if part.FileName() == "" {
+				value, _ := io.ReadAll(part)
+				fields[part.FormName()] = string(value)
+				continue
+			}
+
+			start := make([]byte, len(first))
+			if _, err := io.ReadFull(part, start); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			close(gate)
+
+			end, err := io.ReadAll(part)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			if !bytes.Equal(start, first) || !bytes.Equal(end, rest) {
+				http.Error(w, "File content doesn't match", http.StatusBadRequest)
+				return
+			}
+
+			fields[part.FormName()] = part.FileName()
+		}
+
+		if fields["name"] != "Song" || fields["number"] != "7" || fields["bestQualityFile"] != "song.flac" {
+			http.Error(w, fmt.Sprintf("Unexpected fields %v", fields), http.StatusBadRequest)
+			return
+		}
+
+		// NOTE(patrik): The size of the file isn't known up front
+		if r.ContentLength != -1 {
+			http.Error(w, "Expected a chunked request", http.StatusBadRequest)
+			return
+		}
+
+		fmt.Fprint(w, `{"status":200,"data":{"id":"t1"}}`)
+	}))
+	defer ts.Close()
+
+	api := New(ts.URL, WithRetry(0, 0))
+
+	res, err := api.CreateTrack(context.Background(), TrackData{
+		Name:   "Song",
+		Number: 7,
+		BestQualityFile: File{
+			ContentType: "audio/flac",
+			Name:        "song.flac",
+			Content:     &gatedReader{first: first, rest: rest, gate: gate},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Id != "t1" {
+		t.Errorf("Id = %v, want t1", res.Id)
+	}
+}
+
+func TestFormContentLength(t *testing.T) {
+	form := newForm(
+		textField("name", "Song"),
+		fileField("coverArt", &File{
+			ContentType: "image/png",
+			Name:        "cover.png",
+			Content:     strings.NewReader("not really a png"),
+		}),
+	)
+
+	// NOTE(patrik): The length has to be known before the form is sent
+	length := form.ContentLength()
+
+	var b bytes.Buffer
+	n, err := form.WriteTo(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != int64(b.Len()) {
+		t.Errorf("WriteTo = %v, wrote %v bytes", n, b.Len())
+	}
+
+	if length != n {
+		t.Errorf("ContentLength = %v, want %v", length, n)
+	}
+}
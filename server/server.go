@@ -1,71 +1,264 @@
 package server
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"mime/multipart"
+	"math"
+	"net"
 	"net/http"
-	"net/textproto"
+	"net/url"
 	"strconv"
+	"time"
 
 	"github.com/nanoteck137/dwebble/types"
 )
 
 type Server struct {
 	baseUrl string
+	client  *http.Client
+
+	maxRetries int
+	retryDelay time.Duration
+
+	auth auth
+}
+
+type Option func(server *Server)
+
+// WithHTTPClient sets the client used for all requests
+func WithHTTPClient(client *http.Client) Option {
+	return func(server *Server) {
+		server.client = client
+	}
+}
+
+// WithTimeout sets the timeout for a single request, including reading
+// the response
+func WithTimeout(timeout time.Duration) Option {
+	return func(server *Server) {
+		client := *server.client
+		client.Timeout = timeout
+		server.client = &client
+	}
 }
 
-func New(baseUrl string) *Server {
-	return &Server{
+// WithRetry sets how many times a failed request is retried and the
+// delay before the first retry, the delay is doubled for every retry
+func WithRetry(maxRetries int, delay time.Duration) Option {
+	return func(server *Server) {
+		server.maxRetries = maxRetries
+		server.retryDelay = delay
+	}
+}
+
+func New(baseUrl string, options ...Option) *Server {
+	server := &Server{
 		baseUrl: baseUrl,
+		client: &http.Client{
+			Timeout: 5 * time.Minute,
+		},
+		maxRetries: 3,
+		retryDelay: 500 * time.Millisecond,
+	}
+
+	for _, option := range options {
+		option(server)
 	}
+
+	return server
 }
 
+// NOTE(patrik): The MusicBrainz ids are sent as extra fields, servers
+// that don't store them ignore the fields
 type ArtistData struct {
 	Name    string
+	Mbid    string
 	Picture io.Reader
 }
 
-func (server *Server) newReq(method, endpoint string, body io.Reader) (*http.Request, error) {
+func (server *Server) newRawReq(ctx context.Context, method, endpoint string, body io.Reader) (*http.Request, error) {
 	url := server.baseUrl + endpoint
-	return http.NewRequest(method, url, body)
+	return http.NewRequestWithContext(ctx, method, url, body)
 }
 
-func (server *Server) CreateArtist(data ArtistData) (*types.ApiPostArtistData, error) {
-	var b bytes.Buffer
-	form := multipart.NewWriter(&b)
-
-	if err := form.WriteField("name", data.Name); err != nil {
+// newReq creates a request with the credentials attached
+func (server *Server) newReq(ctx context.Context, method, endpoint string, body io.Reader) (*http.Request, error) {
+	token, err := server.authToken(ctx)
+	if err != nil {
 		return nil, err
 	}
 
-	if err := form.Close(); err != nil {
+	req, err := server.newRawReq(ctx, method, endpoint, body)
+	if err != nil {
 		return nil, err
 	}
 
-	req, err := server.newReq("POST", "/artists", &b)
-	if err != nil {
-		return nil, err
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
 	}
 
-	req.Header.Set("Content-Type", form.FormDataContentType())
+	return req, nil
+}
 
-	res, err := http.DefaultClient.Do(req)
+// do sends req and returns the response body, any non 200 response is
+// returned as an *ApiError. The returned bool reports if the server
+// responded at all
+func (server *Server) do(req *http.Request, endpoint string) ([]byte, bool, error) {
+	res, err := server.client.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 	defer res.Body.Close()
 
-	body, err := io.ReadAll(res.Body)
+	data, err := io.ReadAll(res.Body)
 	if err != nil {
-		return nil, err
+		return nil, true, err
 	}
 
 	if res.StatusCode != 200 {
-		return nil, errors.New("Request error: " + string(body))
+		return nil, true, newApiError(req.Method, endpoint, res.StatusCode, data)
+	}
+
+	return data, true, nil
+}
+
+// retry runs fn until it succeeds, fn returns false as the second value
+// when the request can't be sent again. A request rejected as
+// unauthorized is sent once more after signing in again
+func (server *Server) retry(ctx context.Context, fn func() ([]byte, bool, error)) ([]byte, error) {
+	delay := server.retryDelay
+	refreshed := false
+
+	for attempt := 0; ; attempt++ {
+		data, retryable, err := fn()
+
+		if errors.Is(err, ErrUnauthorized) {
+			if !retryable || refreshed || !server.auth.canLogin() {
+				return nil, err
+			}
+
+			refreshed = true
+			if err := server.refreshToken(ctx); err != nil {
+				return nil, err
+			}
+
+			attempt--
+			continue
+		}
+
+		if err == nil || !retryable || attempt >= server.maxRetries || ctx.Err() != nil {
+			return data, err
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		case <-timer.C:
+		}
+
+		delay *= 2
+	}
+}
+
+func isRetryableStatus(err error) bool {
+	var apiErr *ApiError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+
+	switch apiErr.StatusCode {
+	case http.StatusTooManyRequests,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+
+	return false
+}
+
+// get sends a GET request, failed requests are retried on network
+// errors and on temporary server errors
+func (server *Server) get(ctx context.Context, endpoint string) ([]byte, error) {
+	return server.retry(ctx, func() ([]byte, bool, error) {
+		req, err := server.newReq(ctx, "GET", endpoint, nil)
+		if err != nil {
+			return nil, false, err
+		}
+
+		data, responded, err := server.do(req, endpoint)
+		if err != nil {
+			return nil, !responded || isRetryableStatus(err) || errors.Is(err, ErrUnauthorized), err
+		}
+
+		return data, false, nil
+	})
+}
+
+// isDialError reports if err happened while connecting to the server,
+// in that case nothing was sent
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// postForm streams form to endpoint and returns the response body, the
+// request is only retried if it never reached the server and the form
+// can be sent again.
+//
+// NOTE(patrik): A connection that drops after the form was sent might
+// still have created the entity, sending it again would create a
+// duplicate
+func (server *Server) postForm(ctx context.Context, endpoint string, form *Form) ([]byte, error) {
+	form.mark()
+	first := true
+
+	return server.retry(ctx, func() ([]byte, bool, error) {
+		if !first {
+			if err := form.rewind(); err != nil {
+				return nil, false, err
+			}
+		}
+		first = false
+
+		body := form.Reader()
+		defer body.Close()
+
+		req, err := server.newReq(ctx, "POST", endpoint, body)
+		if err != nil {
+			return nil, false, err
+		}
+
+		req.Header.Set("Content-Type", form.ContentType())
+		req.ContentLength = form.ContentLength()
+
+		data, responded, err := server.do(req, endpoint)
+		if err != nil {
+			resend := (!responded && isDialError(err)) || errors.Is(err, ErrUnauthorized)
+			return nil, resend && form.canRewind(), err
+		}
+
+		return data, false, nil
+	})
+}
+
+func (server *Server) CreateArtist(ctx context.Context, data ArtistData) (*types.ApiPostArtistData, error) {
+	form := newForm(
+		textField("name", data.Name),
+	)
+
+	if data.Mbid != "" {
+		form.add(textField("mbid", data.Mbid))
+	}
+
+	body, err := server.postForm(ctx, "/artists", form)
+	if err != nil {
+		return nil, err
 	}
 
 	var response types.ApiResponse[types.ApiPostArtistData]
@@ -79,47 +272,41 @@ func (server *Server) CreateArtist(data ArtistData) (*types.ApiPostArtistData, e
 type AlbumData struct {
 	Name     string
 	ArtistId string
-	CoverArt io.Reader
-}
+	// NOTE(patrik): Other credited artists, ArtistId is the primary one
+	ExtraArtistIds []string
+	CoverArt       File
 
-func (server *Server) CreateAlbum(data AlbumData) (*types.ApiPostAlbumData, error) {
-	var b bytes.Buffer
-	form := multipart.NewWriter(&b)
+	Mbid             string
+	ReleaseGroupMbid string
+}
 
-	if err := form.WriteField("name", data.Name); err != nil {
-		return nil, err
-	}
+func (server *Server) CreateAlbum(ctx context.Context, data AlbumData) (*types.ApiPostAlbumData, error) {
+	form := newForm(
+		textField("name", data.Name),
+		textField("artist", data.ArtistId),
+	)
 
-	if err := form.WriteField("artist", data.ArtistId); err != nil {
-		return nil, err
+	for _, id := range data.ExtraArtistIds {
+		form.add(textField("extraArtists", id))
 	}
 
-	if err := form.Close(); err != nil {
-		return nil, err
+	if data.Mbid != "" {
+		form.add(textField("mbid", data.Mbid))
 	}
 
-	req, err := server.newReq("POST", "/albums", &b)
-	if err != nil {
-		return nil, err
+	if data.ReleaseGroupMbid != "" {
+		form.add(textField("releaseGroupMbid", data.ReleaseGroupMbid))
 	}
 
-	req.Header.Set("Content-Type", form.FormDataContentType())
-
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, err
+	if data.CoverArt.Content != nil {
+		form.add(fileField("coverArt", &data.CoverArt))
 	}
-	defer res.Body.Close()
 
-	body, err := io.ReadAll(res.Body)
+	body, err := server.postForm(ctx, "/albums", form)
 	if err != nil {
 		return nil, err
 	}
 
-	if res.StatusCode != 200 {
-		return nil, errors.New("Request error: " + string(body))
-	}
-
 	var response types.ApiResponse[types.ApiPostAlbumData]
 	if err := json.Unmarshal(body, &response); err != nil {
 		return nil, err
@@ -137,93 +324,61 @@ type File struct {
 type TrackData struct {
 	Name              string
 	Number            int
+	Disc              int
 	AlbumId           string
 	ArtistId          string
+	ExtraArtistIds    []string
+	RecordingMbid     string
+	Duration          float64
 	BestQualityFile   File
 	MobileQualityFile File
 	CoverArt          File
 }
 
-func createFileField(form *multipart.Writer, fieldName string, file *File) error {
-	h := make(textproto.MIMEHeader)
-	dis := fmt.Sprintf(`form-data; name="%s"; filename="%s"`, fieldName, file.Name)
-	h.Set("Content-Disposition", dis)
-	h.Set("Content-Type", file.ContentType)
+func (server *Server) CreateTrack(ctx context.Context, data TrackData) (*types.ApiPostTrackData, error) {
+	form := newForm(
+		textField("name", data.Name),
+		textField("number", strconv.Itoa(data.Number)),
+	)
 
-	writer, err := form.CreatePart(h)
-	if err != nil {
-		return err
-	}
-
-	data, err := io.ReadAll(file.Content)
-	if err != nil {
-		return err
+	if data.Disc > 0 {
+		form.add(textField("disc", strconv.Itoa(data.Disc)))
 	}
 
-	fmt.Printf("File Size: %v -> %v\n", fieldName, len(data))
+	form.add(
+		textField("album", data.AlbumId),
+		textField("artist", data.ArtistId),
+	)
 
-	_, err = writer.Write(data)
-	return err
-}
-
-func (server *Server) CreateTrack(data TrackData) (*types.ApiPostTrackData, error) {
-	var b bytes.Buffer
-	form := multipart.NewWriter(&b)
-
-	if err := form.WriteField("name", data.Name); err != nil {
-		return nil, err
+	for _, id := range data.ExtraArtistIds {
+		form.add(textField("extraArtists", id))
 	}
 
-	if err := form.WriteField("number", strconv.Itoa(data.Number)); err != nil {
-		return nil, err
+	if data.RecordingMbid != "" {
+		form.add(textField("mbid", data.RecordingMbid))
 	}
 
-	if err := form.WriteField("album", data.AlbumId); err != nil {
-		return nil, err
-	}
-
-	if err := form.WriteField("artist", data.ArtistId); err != nil {
-		return nil, err
+	if data.Duration > 0 {
+		form.add(textField("duration", strconv.Itoa(int(math.Round(data.Duration)))))
 	}
 
 	if data.BestQualityFile.Content != nil {
-		createFileField(form, "bestQualityFile", &data.BestQualityFile)
+		form.add(fileField("bestQualityFile", &data.BestQualityFile))
 	}
 
 	if data.MobileQualityFile.Content != nil {
-		createFileField(form, "mobileQualityFile", &data.MobileQualityFile)
+		form.add(fileField("mobileQualityFile", &data.MobileQualityFile))
 	}
 
 	if data.CoverArt.Content != nil {
-		createFileField(form, "coverArt", &data.CoverArt)
-	}
-
-	if err := form.Close(); err != nil {
-		return nil, err
-	}
-
-	req, err := server.newReq("POST", "/tracks", &b)
-	if err != nil {
-		return nil, err
+		form.add(fileField("coverArt", &data.CoverArt))
 	}
 
-	req.Header.Set("Content-Type", form.FormDataContentType())
-
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer res.Body.Close()
-
-	body, err := io.ReadAll(res.Body)
+	body, err := server.postForm(ctx, "/tracks", form)
 	if err != nil {
 		return nil, err
 	}
 
-	if res.StatusCode != 200 {
-		return nil, errors.New("Request error: " + string(body))
-	}
-
 	var response types.ApiResponse[types.ApiPostTrackData]
 	if err := json.Unmarshal(body, &response); err != nil {
 		return nil, err
@@ -232,78 +387,80 @@ func (server *Server) CreateTrack(data TrackData) (*types.ApiPostTrackData, erro
 	return &response.Data, nil
 }
 
-func (server *Server) GetArtists(name ...string) (*types.ApiGetArtistsData, error) {
-	// url := fmt.Sprintf("http://localhost:3000/api/v1/artists?name=%v", url.QueryEscape(name))
-	// req, err := http.NewRequest("GET", url, nil)
+// Artist extends the dwebble artist with the MusicBrainz id, Mbid is
+// empty when the server doesn't store it
+type Artist struct {
+	types.ApiArtist
+	Mbid string `json:"mbid"`
+}
 
+type ArtistsData struct {
+	Artists []Artist `json:"artists"`
+}
+
+// Album extends the dwebble album with the MusicBrainz ids, the ids are
+// empty when the server doesn't store them
+type Album struct {
+	types.ApiAlbum
+	Mbid             string `json:"mbid"`
+	ReleaseGroupMbid string `json:"releaseGroupMbid"`
+}
+
+type AlbumsData struct {
+	Albums []Album `json:"albums"`
+}
+
+func (server *Server) GetArtists(ctx context.Context, name ...string) (*ArtistsData, error) {
 	n := ""
 	if len(name) > 0 {
 		n = name[0]
 	}
 
-	req, err := server.newReq("GET", fmt.Sprintf("/artists?name=%v", n), nil)
+	data, err := server.get(ctx, fmt.Sprintf("/artists?name=%v", url.QueryEscape(n)))
 	if err != nil {
 		return nil, err
 	}
 
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer res.Body.Close()
-
-	data, err := io.ReadAll(res.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	if res.StatusCode != 200 {
-		return nil, errors.New("Request error: " + string(data))
-	}
-
-	var response types.ApiResponse[types.ApiGetArtistsData]
+	var response types.ApiResponse[ArtistsData]
 	err = json.Unmarshal(data, &response)
 	if err != nil {
 		return nil, err
 	}
 
 	return &response.Data, nil
-
 }
 
-func (server *Server) GetArtistAlbums(artistId string, name ...string) (*types.ApiGetArtistAlbumsByIdData, error) {
+func (server *Server) GetArtistAlbums(ctx context.Context, artistId string, name ...string) (*AlbumsData, error) {
 	n := ""
 	if len(name) > 0 {
 		n = name[0]
 	}
 
-	req, err := server.newReq("GET", fmt.Sprintf("/artists/%v/albums?name=%v", artistId, n), nil)
+	data, err := server.get(ctx, fmt.Sprintf("/artists/%v/albums?name=%v", url.PathEscape(artistId), url.QueryEscape(n)))
 	if err != nil {
 		return nil, err
 	}
 
-	res, err := http.DefaultClient.Do(req)
+	var response types.ApiResponse[AlbumsData]
+	err = json.Unmarshal(data, &response)
 	if err != nil {
 		return nil, err
 	}
-	defer res.Body.Close()
 
-	data, err := io.ReadAll(res.Body)
+	return &response.Data, nil
+}
+
+func (server *Server) GetAlbumTracks(ctx context.Context, albumId string) (*types.ApiGetAlbumTracksByIdData, error) {
+	data, err := server.get(ctx, fmt.Sprintf("/albums/%v/tracks", url.PathEscape(albumId)))
 	if err != nil {
 		return nil, err
 	}
 
-	if res.StatusCode != 200 {
-		return nil, errors.New("Request error: " + string(data))
-	}
-
-	var response types.ApiResponse[types.ApiGetArtistAlbumsByIdData]
+	var response types.ApiResponse[types.ApiGetAlbumTracksByIdData]
 	err = json.Unmarshal(data, &response)
 	if err != nil {
 		return nil, err
 	}
 
 	return &response.Data, nil
-
 }
-
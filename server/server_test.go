@@ -0,0 +1,222 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// dialFailTransport fails the first fails requests as if the server
+// couldn't be reached, the rest are sent using http.DefaultTransport
+type dialFailTransport struct {
+	fails    int32
+	attempts atomic.Int32
+}
+
+func (t *dialFailTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.attempts.Add(1) <= t.fails {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+	}
+
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func newTestServer(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func testFile(t *testing.T, content string) File {
+	p := path.Join(t.TempDir(), "cover.png")
+	if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+
+	return File{
+		ContentType: "image/png",
+		Name:        "cover.png",
+		Content:     f,
+	}
+}
+
+func TestGetRetry(t *testing.T) {
+	var requests atomic.Int32
+	ts := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+
+		fmt.Fprint(w, `{"status":200,"data":{"artists":[{"id":"a1","name":"Metallica"}]}}`)
+	})
+
+	api := New(ts.URL, WithRetry(3, time.Millisecond))
+
+	res, err := api.GetArtists(context.Background(), "Metallica")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res.Artists) != 1 || res.Artists[0].Id != "a1" {
+		t.Errorf("Artists = %+v", res.Artists)
+	}
+
+	if n := requests.Load(); n != 2 {
+		t.Errorf("Server got %v requests, want 2", n)
+	}
+}
+
+func TestGetRetryLimit(t *testing.T) {
+	var requests atomic.Int32
+	ts := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	api := New(ts.URL, WithRetry(2, time.Millisecond))
+
+	_, err := api.GetArtists(context.Background())
+	if !errors.Is(err, ErrServer) {
+		t.Fatalf("err = %v, want %v", err, ErrServer)
+	}
+
+	if n := requests.Load(); n != 3 {
+		t.Errorf("Server got %v requests, want 3", n)
+	}
+}
+
+func TestPostNotRetriedOnStatus(t *testing.T) {
+	var requests atomic.Int32
+	ts := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	})
+
+	api := New(ts.URL, WithRetry(3, time.Millisecond))
+
+	_, err := api.CreateArtist(context.Background(), ArtistData{Name: "Metallica"})
+	if !errors.Is(err, ErrServer) {
+		t.Fatalf("err = %v, want %v", err, ErrServer)
+	}
+
+	if n := requests.Load(); n != 1 {
+		t.Errorf("Server got %v requests, want 1", n)
+	}
+}
+
+func TestPostRetriedOnDialError(t *testing.T) {
+	var requests atomic.Int32
+	var covers []string
+	ts := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+
+		file, _, err := r.FormFile("coverArt")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+
+		data, _ := io.ReadAll(file)
+		covers = append(covers, string(data))
+
+		fmt.Fprint(w, `{"status":200,"data":{"id":"al1"}}`)
+	})
+
+	transport := &dialFailTransport{fails: 1}
+	api := New(ts.URL, WithHTTPClient(&http.Client{Transport: transport}), WithRetry(3, time.Millisecond))
+
+	res, err := api.CreateAlbum(context.Background(), AlbumData{
+		Name:     "Metallica",
+		ArtistId: "a1",
+		CoverArt: testFile(t, "cover"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Id != "al1" {
+		t.Errorf("Id = %v, want al1", res.Id)
+	}
+
+	if n := transport.attempts.Load(); n != 2 {
+		t.Errorf("Sent %v requests, want 2", n)
+	}
+
+	// NOTE(patrik): The file needs to be sent from the start again
+	if requests.Load() != 1 || len(covers) != 1 || covers[0] != "cover" {
+		t.Errorf("Server got covers %q, want [\"cover\"]", covers)
+	}
+}
+
+func TestPostNotRetriedWithoutRewind(t *testing.T) {
+	transport := &dialFailTransport{fails: 1}
+	api := New("http://127.0.0.1", WithHTTPClient(&http.Client{Transport: transport}), WithRetry(3, time.Millisecond))
+
+	_, err := api.CreateAlbum(context.Background(), AlbumData{
+		Name:     "Metallica",
+		ArtistId: "a1",
+		CoverArt: File{
+			ContentType: "image/png",
+			Name:        "cover.png",
+			Content:     io.LimitReader(strings.NewReader("cover"), 5),
+		},
+	})
+	if !isDialError(err) {
+		t.Fatalf("err = %v, want a dial error", err)
+	}
+
+	if n := transport.attempts.Load(); n != 1 {
+		t.Errorf("Sent %v requests, want 1", n)
+	}
+}
+
+func TestPostNotRetriedAfterSend(t *testing.T) {
+	var requests atomic.Int32
+	ts := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		io.Copy(io.Discard, r.Body)
+
+		// NOTE(patrik): The server got the whole form but the connection
+		// drops before it responds
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn.Close()
+	})
+
+	api := New(ts.URL, WithRetry(3, time.Millisecond))
+
+	_, err := api.CreateArtist(context.Background(), ArtistData{Name: "Metallica"})
+	if err == nil {
+		t.Fatal("Expected an error")
+	}
+
+	if n := requests.Load(); n != 1 {
+		t.Errorf("Server got %v requests, want 1", n)
+	}
+}
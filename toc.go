@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/nanoteck137/dwebble-importer/discid"
+	"github.com/nanoteck137/dwebble-importer/utils"
+)
+
+func filesWithExt(dir, ext string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.EqualFold(path.Ext(entry.Name()), ext) {
+			files = append(files, path.Join(dir, entry.Name()))
+		}
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+func readLogTOC(p string) (*discid.TOC, error) {
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+
+	return discid.ParseLog(data)
+}
+
+func readCueTOC(p string) (*discid.TOC, error) {
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+
+	cue, err := discid.ParseCue(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return cue.TOC(func(file string) (int, error) {
+		probe, err := utils.ProbeFile(path.Join(path.Dir(p), file))
+		if err != nil {
+			return 0, fmt.Errorf("Failed to probe '%v' from cue sheet: %w", file, err)
+		}
+
+		if probe.Duration <= 0 {
+			return 0, fmt.Errorf("Unknown duration for '%v' from cue sheet", file)
+		}
+
+		return int(math.Round(probe.Duration * discid.SectorsPerSecond)), nil
+	})
+}
+
+// findDiscTOC looks for a rip log or a cue sheet in dirs and reads the
+// TOC of the disc from the first one that has one. Logs are tried first
+// since they have the exact TOC, cue sheets need the length of the audio
+// files. Returns nil if no usable file was found
+func findDiscTOC(dirs []string) (*discid.TOC, string) {
+	for _, ext := range []string{".log", ".cue"} {
+		for _, dir := range dirs {
+			files, err := filesWithExt(dir, ext)
+			if err != nil {
+				continue
+			}
+
+			for _, file := range files {
+				var toc *discid.TOC
+				if ext == ".log" {
+					toc, err = readLogTOC(file)
+				} else {
+					toc, err = readCueTOC(file)
+				}
+
+				if err != nil {
+					fmt.Printf("Skipping '%v': %v\n", file, err)
+					continue
+				}
+
+				return toc, file
+			}
+		}
+	}
+
+	return nil, ""
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/nanoteck137/dwebble-importer/utils"
+)
+
+// transcodeError is returned when ffmpeg fails for a track, it only
+// affects that track
+type transcodeError struct {
+	file string
+	err  error
+}
+
+func (err *transcodeError) Error() string {
+	return fmt.Sprintf("Failed to transcode '%v': %v", err.file, err.err)
+}
+
+func (err *transcodeError) Unwrap() error {
+	return err.err
+}
+
+// transcodeFunc transcodes a single track, transcodeTrack is used for
+// imports and tests can swap it out so ffmpeg isn't needed
+type transcodeFunc func(ctx context.Context, track PlanTrack, journal *Journal, verbose bool) error
+
+// transcodeTrack creates the best and mobile quality files for a track,
+// steps already recorded as done in the journal are skipped
+func transcodeTrack(ctx context.Context, track PlanTrack, journal *Journal, verbose bool) error {
+	entry := journal.Track(track.Disc, track.Number)
+
+	if !entry.BestQualityDone {
+		err := utils.RunFFmpegContext(ctx, verbose, track.BestQualityArgs...)
+		if err != nil {
+			return &transcodeError{file: track.SourceFile, err: err}
+		}
+
+		err = journal.UpdateTrack(track.Disc, track.Number, func(entry *JournalTrack) {
+			entry.BestQualityDone = true
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if !entry.MobileQualityDone {
+		err := utils.RunFFmpegContext(ctx, verbose, track.MobileQualityArgs...)
+		if err != nil {
+			return &transcodeError{file: track.SourceFile, err: err}
+		}
+
+		err = journal.UpdateTrack(track.Disc, track.Number, func(entry *JournalTrack) {
+			entry.MobileQualityDone = true
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// transcodeTracks runs transcode for the tracks using a pool of jobs
+// workers, the returned slice has the error for each track in the same
+// order as tracks. A *transcodeError only fails that track while any
+// other error (e.g. the journal can't be saved) is fatal, it cancels all
+// the remaining work and is returned as the second value
+func transcodeTracks(ctx context.Context, tracks []PlanTrack, journal *Journal, jobs int, transcode transcodeFunc) ([]error, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if jobs > len(tracks) {
+		jobs = len(tracks)
+	}
+
+	// NOTE(patrik): Output from multiple ffmpeg processes gets mixed up
+	// so only show it when running one at a time
+	verbose := jobs == 1
+
+	trackErrs := make([]error, len(tracks))
+
+	var fatalOnce sync.Once
+	var fatalErr error
+
+	indices := make(chan int)
+	var wg sync.WaitGroup
+
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for index := range indices {
+				// NOTE(patrik): A track can still be handed out after a
+				// fatal error, don't start it
+				if ctx.Err() != nil {
+					continue
+				}
+
+				track := tracks[index]
+
+				err := transcode(ctx, track, journal, verbose)
+				if err != nil {
+					var trackErr *transcodeError
+					if errors.As(err, &trackErr) {
+						trackErrs[index] = err
+						continue
+					}
+
+					fatalOnce.Do(func() {
+						fatalErr = err
+						cancel()
+					})
+					continue
+				}
+
+				fmt.Printf("Transcoded track %v-%v - %v\n", track.Disc, track.Number, track.Name)
+			}
+		}()
+	}
+
+loop:
+	for i := range tracks {
+		select {
+		case indices <- i:
+		case <-ctx.Done():
+			break loop
+		}
+	}
+
+	close(indices)
+	wg.Wait()
+
+	if fatalErr != nil {
+		return nil, fatalErr
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return trackErrs, nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testTracks(count int) []PlanTrack {
+	tracks := make([]PlanTrack, count)
+	for i := range tracks {
+		tracks[i] = PlanTrack{
+			Name:       "Track",
+			Number:     i + 1,
+			Disc:       1,
+			SourceFile: "track.flac",
+		}
+	}
+
+	return tracks
+}
+
+func testJournal(t *testing.T) *Journal {
+	journal, _, err := openJournal(t.TempDir(), "album")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return journal
+}
+
+func TestTranscodeTracks(t *testing.T) {
+	tracks := testTracks(8)
+	errFFmpeg := errors.New("exit status 1")
+
+	transcode := func(ctx context.Context, track PlanTrack, journal *Journal, verbose bool) error {
+		// NOTE(patrik): Make the early tracks finish last so the result
+		// can't line up with the tracks by accident
+		time.Sleep(time.Duration(len(tracks)-track.Number) * time.Millisecond)
+
+		if track.Number == 3 {
+			return &transcodeError{file: track.SourceFile, err: errFFmpeg}
+		}
+
+		return nil
+	}
+
+	trackErrs, err := transcodeTracks(context.Background(), tracks, testJournal(t), 4, transcode)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(trackErrs) != len(tracks) {
+		t.Fatalf("len(trackErrs) = %v, want %v", len(trackErrs), len(tracks))
+	}
+
+	for i, trackErr := range trackErrs {
+		if tracks[i].Number == 3 {
+			if !errors.Is(trackErr, errFFmpeg) {
+				t.Errorf("trackErrs[%v] = %v, want %v", i, trackErr, errFFmpeg)
+			}
+			continue
+		}
+
+		if trackErr != nil {
+			t.Errorf("trackErrs[%v] = %v, want nil", i, trackErr)
+		}
+	}
+}
+
+func TestTranscodeTracksFatal(t *testing.T) {
+	tracks := testTracks(6)
+	errFatal := errors.New("journal can't be saved")
+
+	var mu sync.Mutex
+	var started []int
+
+	transcode := func(ctx context.Context, track PlanTrack, journal *Journal, verbose bool) error {
+		mu.Lock()
+		started = append(started, track.Number)
+		mu.Unlock()
+
+		if track.Number == 2 {
+			return errFatal
+		}
+
+		return nil
+	}
+
+	trackErrs, err := transcodeTracks(context.Background(), tracks, testJournal(t), 1, transcode)
+	if !errors.Is(err, errFatal) {
+		t.Fatalf("err = %v, want %v", err, errFatal)
+	}
+
+	if trackErrs != nil {
+		t.Errorf("trackErrs = %v, want nil", trackErrs)
+	}
+
+	// NOTE(patrik): With a single worker nothing after the fatal track
+	// may be started
+	if len(started) != 2 || started[0] != 1 || started[1] != 2 {
+		t.Errorf("started = %v, want [1 2]", started)
+	}
+}
+
+func TestTranscodeTracksCanceled(t *testing.T) {
+	tracks := testTracks(4)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	transcode := func(ctx context.Context, track PlanTrack, journal *Journal, verbose bool) error {
+		if track.Number == 1 {
+			cancel()
+		}
+
+		return ctx.Err()
+	}
+
+	_, err := transcodeTracks(ctx, tracks, testJournal(t), 2, transcode)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want %v", err, context.Canceled)
+	}
+}
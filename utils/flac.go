@@ -0,0 +1,171 @@
+package utils
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	flacStreamInfo    = 0
+	flacVorbisComment = 4
+	flacPicture       = 6
+)
+
+// NOTE(patrik): https://www.xiph.org/vorbis/doc/v-comment.html, the
+// album artist has no official name so the common ones are used
+var vorbisKeys = map[string]string{
+	"ARTIST":       "artist",
+	"ALBUMARTIST":  "album_artist",
+	"ALBUM ARTIST": "album_artist",
+	"ALBUM_ARTIST": "album_artist",
+	"TITLE":        "title",
+	"ALBUM":        "album",
+	"TRACKNUMBER":  "track",
+	"DISCNUMBER":   "disc",
+}
+
+// probeFlac reads the metadata blocks of a FLAC file, r is positioned
+// right after the fLaC marker
+func probeFlac(r io.ReadSeeker, size int64) (ProbeResult, error) {
+	tags := make(nativeTags)
+	audio := AudioStream{
+		Index: 0,
+		Codec: "flac",
+	}
+
+	var pictures []PictureStream
+	foundInfo := false
+
+	for {
+		header := make([]byte, 4)
+		_, err := io.ReadFull(r, header)
+		if err != nil {
+			return ProbeResult{}, fmt.Errorf("Malformed FLAC metadata: %w", err)
+		}
+
+		last := header[0]&0x80 != 0
+		typ := header[0] & 0x7f
+		length := int(header[1])<<16 | int(header[2])<<8 | int(header[3])
+
+		switch typ {
+		case flacStreamInfo, flacVorbisComment, flacPicture:
+			data := make([]byte, length)
+			_, err := io.ReadFull(r, data)
+			if err != nil {
+				return ProbeResult{}, fmt.Errorf("Malformed FLAC metadata: %w", err)
+			}
+
+			switch typ {
+			case flacStreamInfo:
+				err = readFlacStreamInfo(data, &audio)
+				foundInfo = true
+			case flacVorbisComment:
+				err = readVorbisComments(data, tags)
+			case flacPicture:
+				var picture PictureStream
+				picture, err = readFlacPicture(data, len(pictures)+1)
+				pictures = append(pictures, picture)
+			}
+
+			if err != nil {
+				return ProbeResult{}, err
+			}
+		default:
+			_, err := r.Seek(int64(length), io.SeekCurrent)
+			if err != nil {
+				return ProbeResult{}, err
+			}
+		}
+
+		if last {
+			break
+		}
+	}
+
+	if !foundInfo {
+		return ProbeResult{}, fmt.Errorf("Missing FLAC STREAMINFO block")
+	}
+
+	return tags.result("flac", size, audio, pictures), nil
+}
+
+func readFlacStreamInfo(data []byte, audio *AudioStream) error {
+	if len(data) < 18 {
+		return fmt.Errorf("Malformed FLAC STREAMINFO block")
+	}
+
+	// NOTE(patrik): After the block and frame sizes (10 bytes) comes the
+	// sample rate (20 bits), channels - 1 (3 bits), bits per sample - 1
+	// (5 bits) and the number of samples (36 bits)
+	audio.SampleRate = int(data[10])<<12 | int(data[11])<<4 | int(data[12])>>4
+	audio.Channels = int(data[12]>>1&0x7) + 1
+	audio.BitDepth = (int(data[12]&0x1)<<4 | int(data[13]>>4)) + 1
+
+	samples := int64(data[13]&0xf)<<32 | int64(binary.BigEndian.Uint32(data[14:18]))
+	if audio.SampleRate > 0 {
+		audio.Duration = float64(samples) / float64(audio.SampleRate)
+	}
+
+	return nil
+}
+
+func readVorbisComments(data []byte, tags nativeTags) error {
+	buf := buffer{data: data}
+
+	// Vendor
+	buf.bytes(buf.u32le())
+
+	count := buf.u32le()
+	for i := 0; i < count && !buf.overflow; i++ {
+		comment := string(buf.bytes(buf.u32le()))
+
+		key, value, found := strings.Cut(comment, "=")
+		if !found {
+			continue
+		}
+
+		if name, ok := vorbisKeys[strings.ToUpper(key)]; ok {
+			tags.set(name, value)
+		}
+	}
+
+	if buf.overflow {
+		return fmt.Errorf("Malformed FLAC VORBIS_COMMENT block")
+	}
+
+	return nil
+}
+
+func readFlacPicture(data []byte, index int) (PictureStream, error) {
+	buf := buffer{data: data}
+
+	typ := buf.u32be()
+	mime := string(buf.bytes(buf.u32be()))
+	// Description
+	buf.bytes(buf.u32be())
+	width := buf.u32be()
+	height := buf.u32be()
+	// Color depth and number of colors
+	buf.bytes(8)
+	picture := buf.bytes(buf.u32be())
+
+	if buf.overflow {
+		return PictureStream{}, fmt.Errorf("Malformed FLAC PICTURE block")
+	}
+
+	if width == 0 || height == 0 {
+		width, height = pictureSize(picture)
+	}
+
+	return PictureStream{
+		Index:  index,
+		Codec:  pictureCodec(mime),
+		Width:  width,
+		Height: height,
+		// NOTE(patrik): Picture type 3 is the front cover
+		Front: typ == 3,
+		Hash:  pictureHash(picture),
+	}, nil
+}
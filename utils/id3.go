@@ -0,0 +1,323 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// NOTE(patrik): ID3v2.2 uses 3 character frame ids
+var id3Frames = map[string]string{
+	"TIT2": "title",
+	"TT2":  "title",
+	"TPE1": "artist",
+	"TP1":  "artist",
+	"TPE2": "album_artist",
+	"TP2":  "album_artist",
+	"TALB": "album",
+	"TAL":  "album",
+	"TRCK": "track",
+	"TRK":  "track",
+	"TPOS": "disc",
+	"TPA":  "disc",
+}
+
+func syncsafe(data []byte) int {
+	return int(data[0]&0x7f)<<21 | int(data[1]&0x7f)<<14 | int(data[2]&0x7f)<<7 | int(data[3]&0x7f)
+}
+
+// id3v2Size returns the size of the ID3v2 tag starting with header,
+// including the header and the footer. Returns 0 when there is no tag
+func id3v2Size(header []byte) int64 {
+	if len(header) < 10 || string(header[:3]) != "ID3" {
+		return 0
+	}
+
+	size := int64(syncsafe(header[6:10])) + 10
+	if header[5]&0x10 != 0 {
+		size += 10
+	}
+
+	return size
+}
+
+// removeUnsync undoes the unsynchronisation scheme, which inserts a 0
+// after every 0xff
+func removeUnsync(data []byte) []byte {
+	res := make([]byte, 0, len(data))
+	for i := 0; i < len(data); i++ {
+		res = append(res, data[i])
+		if data[i] == 0xff && i+1 < len(data) && data[i+1] == 0 {
+			i++
+		}
+	}
+
+	return res
+}
+
+// decodeID3String decodes text with the ID3v2 encoding enc, only the
+// first value is used when the frame has more then one
+func decodeID3String(enc byte, data []byte) string {
+	var s string
+	switch enc {
+	case 1:
+		switch {
+		case bytes.HasPrefix(data, []byte{0xff, 0xfe}):
+			s = decodeUTF16(data[2:], binary.LittleEndian)
+		case bytes.HasPrefix(data, []byte{0xfe, 0xff}):
+			s = decodeUTF16(data[2:], binary.BigEndian)
+		default:
+			s = decodeUTF16(data, binary.BigEndian)
+		}
+	case 2:
+		s = decodeUTF16(data, binary.BigEndian)
+	case 3:
+		s = string(data)
+	default:
+		s = decodeLatin1(data)
+	}
+
+	s, _, _ = strings.Cut(s, "\x00")
+	return s
+}
+
+// skipID3String skips the null terminated string at the start of data
+func skipID3String(enc byte, data []byte) ([]byte, bool) {
+	if enc == 1 || enc == 2 {
+		for i := 0; i+1 < len(data); i += 2 {
+			if data[i] == 0 && data[i+1] == 0 {
+				return data[i+2:], true
+			}
+		}
+
+		return nil, false
+	}
+
+	i := bytes.IndexByte(data, 0)
+	if i == -1 {
+		return nil, false
+	}
+
+	return data[i+1:], true
+}
+
+// id3FrameData strips the extra data the frame flags add in front of the
+// content, returns false for frames that can't be read
+func id3FrameData(version, flags byte, data []byte) ([]byte, bool) {
+	switch version {
+	case 3:
+		// Compressed or encrypted
+		if flags&0xc0 != 0 {
+			return nil, false
+		}
+
+		// Grouping identity
+		if flags&0x20 != 0 && len(data) > 0 {
+			data = data[1:]
+		}
+	case 4:
+		// Compressed or encrypted
+		if flags&0x0c != 0 {
+			return nil, false
+		}
+
+		// Grouping identity
+		if flags&0x40 != 0 && len(data) > 0 {
+			data = data[1:]
+		}
+
+		// Data length indicator
+		if flags&0x01 != 0 {
+			if len(data) < 4 {
+				return nil, false
+			}
+
+			data = data[4:]
+		}
+
+		if flags&0x02 != 0 {
+			data = removeUnsync(data)
+		}
+	}
+
+	return data, true
+}
+
+func readID3Picture(version byte, data []byte, index int) (PictureStream, bool) {
+	if len(data) < 1 {
+		return PictureStream{}, false
+	}
+
+	enc := data[0]
+	data = data[1:]
+
+	var mime string
+	if version == 2 {
+		// NOTE(patrik): ID3v2.2 has a 3 character format (e.g. "JPG")
+		// instead of the mime type
+		if len(data) < 3 {
+			return PictureStream{}, false
+		}
+
+		mime = "image/" + strings.ToLower(string(data[:3]))
+		data = data[3:]
+	} else {
+		i := bytes.IndexByte(data, 0)
+		if i == -1 {
+			return PictureStream{}, false
+		}
+
+		mime = string(data[:i])
+		data = data[i+1:]
+	}
+
+	if len(data) < 1 {
+		return PictureStream{}, false
+	}
+
+	typ := data[0]
+
+	// Description
+	data, ok := skipID3String(enc, data[1:])
+	if !ok {
+		return PictureStream{}, false
+	}
+
+	width, height := pictureSize(data)
+
+	return PictureStream{
+		Index:  index,
+		Codec:  pictureCodec(mime),
+		Width:  width,
+		Height: height,
+		Front:  typ == 3,
+		Hash:   pictureHash(data),
+	}, true
+}
+
+// readID3v2 reads the text frames and the pictures of the ID3v2 tag,
+// tag is the whole tag including the header
+func readID3v2(tag []byte, tags nativeTags) ([]PictureStream, error) {
+	if len(tag) < 10 {
+		return nil, fmt.Errorf("Malformed ID3v2 tag")
+	}
+
+	version := tag[3]
+	flags := tag[5]
+	body := tag[10:]
+
+	if version < 2 || version > 4 {
+		return nil, fmt.Errorf("Unsupported ID3v2 version 2.%v", version)
+	}
+
+	// Footer
+	if flags&0x10 != 0 && len(body) >= 10 {
+		body = body[:len(body)-10]
+	}
+
+	if version < 4 && flags&0x80 != 0 {
+		body = removeUnsync(body)
+	}
+
+	// NOTE(patrik): The flag means the tag is compressed in ID3v2.2,
+	// which no one uses
+	if flags&0x40 != 0 {
+		if version == 2 {
+			return nil, nil
+		}
+
+		if len(body) < 4 {
+			return nil, fmt.Errorf("Malformed ID3v2 extended header")
+		}
+
+		var size int
+		if version == 3 {
+			size = int(binary.BigEndian.Uint32(body)) + 4
+		} else {
+			size = syncsafe(body)
+		}
+
+		if size > len(body) {
+			return nil, fmt.Errorf("Malformed ID3v2 extended header")
+		}
+
+		body = body[size:]
+	}
+
+	headerSize := 10
+	if version == 2 {
+		headerSize = 6
+	}
+
+	var pictures []PictureStream
+	for len(body) >= headerSize {
+		var id string
+		var size int
+		var frameFlags byte
+
+		if version == 2 {
+			id = string(body[:3])
+			size = int(body[3])<<16 | int(body[4])<<8 | int(body[5])
+		} else {
+			id = string(body[:4])
+			if version == 4 {
+				size = syncsafe(body[4:8])
+			} else {
+				size = int(binary.BigEndian.Uint32(body[4:8]))
+			}
+			frameFlags = body[9]
+		}
+
+		// Padding
+		if id[0] == 0 {
+			break
+		}
+
+		if size > len(body)-headerSize {
+			return nil, fmt.Errorf("Malformed ID3v2 frame '%v'", id)
+		}
+
+		data := body[headerSize : headerSize+size]
+		body = body[headerSize+size:]
+
+		data, ok := id3FrameData(version, frameFlags, data)
+		if !ok || len(data) == 0 {
+			continue
+		}
+
+		if id == "APIC" || id == "PIC" {
+			picture, ok := readID3Picture(version, data, len(pictures)+1)
+			if ok {
+				pictures = append(pictures, picture)
+			}
+
+			continue
+		}
+
+		if name, ok := id3Frames[id]; ok {
+			tags.set(name, decodeID3String(data[0], data[1:]))
+		}
+	}
+
+	return pictures, nil
+}
+
+// readID3v1 reads the 128 byte ID3v1 tag at the end of the file
+func readID3v1(tag []byte, tags nativeTags) {
+	field := func(data []byte) string {
+		data, _, _ = bytes.Cut(data, []byte{0})
+		return decodeLatin1(data)
+	}
+
+	tags.set("title", field(tag[3:33]))
+	tags.set("artist", field(tag[33:63]))
+	tags.set("album", field(tag[63:93]))
+
+	// NOTE(patrik): ID3v1.1 stores the track in the last byte of the
+	// comment
+	comment := tag[97:127]
+	if comment[28] == 0 && comment[29] != 0 {
+		tags.set("track", fmt.Sprint(comment[29]))
+	}
+}
@@ -0,0 +1,325 @@
+package utils
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+var mp4Codecs = map[string]string{
+	"mp4a": "aac",
+	"alac": "alac",
+	"fLaC": "flac",
+	"Opus": "opus",
+	"ac-3": "ac3",
+	"ec-3": "eac3",
+}
+
+var mp4Tags = map[string]string{
+	"\xa9nam": "title",
+	"\xa9ART": "artist",
+	"aART":    "album_artist",
+	"\xa9alb": "album",
+}
+
+// mp4Atoms calls fn with the type and the content of every atom in data
+func mp4Atoms(data []byte, fn func(typ string, content []byte) error) error {
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data))
+		typ := string(data[4:8])
+		header := uint64(8)
+
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return fmt.Errorf("Malformed MP4 atom '%v'", typ)
+			}
+
+			size = binary.BigEndian.Uint64(data[8:])
+			header = 16
+		}
+
+		if size < header || size > uint64(len(data)) {
+			return fmt.Errorf("Malformed MP4 atom '%v'", typ)
+		}
+
+		err := fn(typ, data[header:size])
+		if err != nil {
+			return err
+		}
+
+		data = data[size:]
+	}
+
+	return nil
+}
+
+// mp4Child returns the content of the first atom in data with the type
+// typ, nil if there is none
+func mp4Child(data []byte, typ string) []byte {
+	var res []byte
+	mp4Atoms(data, func(t string, content []byte) error {
+		if res == nil && t == typ {
+			res = content
+		}
+
+		return nil
+	})
+
+	return res
+}
+
+// mp4Duration reads the duration in seconds from a mvhd or mdhd atom
+func mp4Duration(data []byte) float64 {
+	var timescale, duration uint64
+
+	if len(data) >= 32 && data[0] == 1 {
+		timescale = uint64(binary.BigEndian.Uint32(data[20:]))
+		duration = binary.BigEndian.Uint64(data[24:])
+	} else if len(data) >= 20 {
+		timescale = uint64(binary.BigEndian.Uint32(data[12:]))
+		duration = uint64(binary.BigEndian.Uint32(data[16:]))
+	}
+
+	if timescale == 0 {
+		return 0
+	}
+
+	return float64(duration) / float64(timescale)
+}
+
+// readMP4Track returns the audio stream of a trak atom, nil when the
+// track isn't an audio track
+func readMP4Track(trak []byte) *AudioStream {
+	mdia := mp4Child(trak, "mdia")
+	hdlr := mp4Child(mdia, "hdlr")
+	if len(hdlr) < 12 || string(hdlr[8:12]) != "soun" {
+		return nil
+	}
+
+	audio := &AudioStream{
+		Duration: mp4Duration(mp4Child(mdia, "mdhd")),
+	}
+
+	// NOTE(patrik): stsd has version, flags and the number of entries
+	// before the first sample entry, which is 36 bytes before the atoms
+	// specific to the codec
+	stsd := mp4Child(mp4Child(mp4Child(mdia, "minf"), "stbl"), "stsd")
+	if len(stsd) < 8+36 {
+		return audio
+	}
+
+	entry := stsd[8:]
+	size := int(binary.BigEndian.Uint32(entry))
+	if size < 36 || size > len(entry) {
+		return audio
+	}
+
+	format := string(entry[4:8])
+	audio.Codec = format
+	if codec, ok := mp4Codecs[format]; ok {
+		audio.Codec = codec
+	}
+
+	audio.Channels = int(binary.BigEndian.Uint16(entry[24:]))
+	audio.SampleRate = int(binary.BigEndian.Uint32(entry[32:]) >> 16)
+
+	switch format {
+	case "alac":
+		// NOTE(patrik): The sample entry can't hold sample rates above
+		// 65535, the magic cookie has the real values
+		cookie := mp4Child(entry[36:size], "alac")
+		if len(cookie) >= 28 {
+			audio.BitDepth = int(cookie[9])
+			audio.Channels = int(cookie[13])
+			audio.BitRate = int(binary.BigEndian.Uint32(cookie[20:]))
+			audio.SampleRate = int(binary.BigEndian.Uint32(cookie[24:]))
+		}
+	case "fLaC":
+		audio.BitDepth = int(binary.BigEndian.Uint16(entry[26:]))
+	}
+
+	return audio
+}
+
+func readMP4Items(ilst []byte, tags nativeTags, covers *[]PictureStream) error {
+	return mp4Atoms(ilst, func(typ string, item []byte) error {
+		return mp4Atoms(item, func(t string, data []byte) error {
+			if t != "data" || len(data) < 8 {
+				return nil
+			}
+
+			// NOTE(patrik): The data atom starts with the type of the
+			// value and the locale
+			kind := binary.BigEndian.Uint32(data) & 0xffffff
+			value := data[8:]
+
+			switch typ {
+			case "trkn", "disk":
+				if len(value) < 6 {
+					return nil
+				}
+
+				key := "track"
+				if typ == "disk" {
+					key = "disc"
+				}
+
+				// NOTE(patrik): Taggers write 0 when only the total is
+				// known, which means the number is missing
+				num := binary.BigEndian.Uint16(value[2:])
+				total := binary.BigEndian.Uint16(value[4:])
+				if num == 0 {
+					return nil
+				}
+
+				if total > 0 {
+					tags.set(key, fmt.Sprintf("%v/%v", num, total))
+				} else {
+					tags.set(key, fmt.Sprint(num))
+				}
+			case "covr":
+				codec := "mjpeg"
+				switch kind {
+				case 14:
+					codec = "png"
+				case 27:
+					codec = "bmp"
+				}
+
+				width, height := pictureSize(value)
+				*covers = append(*covers, PictureStream{
+					Codec:  codec,
+					Width:  width,
+					Height: height,
+					Hash:   pictureHash(value),
+				})
+			default:
+				if name, ok := mp4Tags[typ]; ok {
+					tags.set(name, string(value))
+				}
+			}
+
+			return nil
+		})
+	})
+}
+
+func readMP4Meta(meta []byte, tags nativeTags, covers *[]PictureStream) error {
+	// NOTE(patrik): meta is a full atom with a version and flags, except
+	// in some QuickTime files where the first child comes right away
+	if len(meta) >= 8 && string(meta[4:8]) != "hdlr" {
+		meta = meta[4:]
+	}
+
+	ilst := mp4Child(meta, "ilst")
+	if ilst == nil {
+		return nil
+	}
+
+	return readMP4Items(ilst, tags, covers)
+}
+
+func probeMP4(r io.ReadSeeker, size int64) (ProbeResult, error) {
+	var moov []byte
+
+	// NOTE(patrik): Only moov is read since mdat is the audio
+	offset := int64(0)
+	for offset+8 <= size {
+		_, err := r.Seek(offset, io.SeekStart)
+		if err != nil {
+			return ProbeResult{}, err
+		}
+
+		header := make([]byte, 16)
+		_, err = io.ReadFull(r, header[:8])
+		if err != nil {
+			return ProbeResult{}, err
+		}
+
+		atomSize := int64(binary.BigEndian.Uint32(header))
+		typ := string(header[4:8])
+		headerSize := int64(8)
+
+		switch atomSize {
+		case 0:
+			atomSize = size - offset
+		case 1:
+			_, err = io.ReadFull(r, header[8:])
+			if err != nil {
+				return ProbeResult{}, err
+			}
+
+			atomSize = int64(binary.BigEndian.Uint64(header[8:]))
+			headerSize = 16
+		}
+
+		if atomSize < headerSize || atomSize > size-offset {
+			return ProbeResult{}, fmt.Errorf("Malformed MP4 atom '%v'", typ)
+		}
+
+		if typ == "moov" {
+			moov = make([]byte, atomSize-headerSize)
+			_, err = io.ReadFull(r, moov)
+			if err != nil {
+				return ProbeResult{}, err
+			}
+
+			break
+		}
+
+		offset += atomSize
+	}
+
+	if moov == nil {
+		return ProbeResult{}, fmt.Errorf("No moov atom found")
+	}
+
+	tags := make(nativeTags)
+	var audio *AudioStream
+	var covers []PictureStream
+	duration := 0.0
+	tracks := 0
+
+	err := mp4Atoms(moov, func(typ string, content []byte) error {
+		switch typ {
+		case "mvhd":
+			duration = mp4Duration(content)
+		case "trak":
+			if stream := readMP4Track(content); stream != nil && audio == nil {
+				stream.Index = tracks
+				audio = stream
+			}
+
+			tracks++
+		case "udta":
+			if meta := mp4Child(content, "meta"); meta != nil {
+				return readMP4Meta(meta, tags, &covers)
+			}
+		case "meta":
+			return readMP4Meta(content, tags, &covers)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return ProbeResult{}, err
+	}
+
+	if audio == nil {
+		return ProbeResult{}, fmt.Errorf("No audio track found")
+	}
+
+	if audio.Duration == 0 {
+		audio.Duration = duration
+	}
+
+	// NOTE(patrik): ffmpeg puts the cover pictures after the tracks
+	for i := range covers {
+		covers[i].Index = tracks + i
+	}
+
+	return tags.result("mov,mp4,m4a,3gp,3g2,mj2", size, *audio, covers), nil
+}
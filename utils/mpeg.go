@@ -0,0 +1,230 @@
+package utils
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// NOTE(patrik): In kbit/s, index 0 is free format which isn't supported
+var mpeg1BitRates = [3][15]int{
+	{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+	{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+	{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+}
+
+var mpeg2BitRates = [3][15]int{
+	{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+	{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+	{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+}
+
+// NOTE(patrik): The first frame is searched for in this many bytes after
+// the ID3v2 tag
+const mpegSearchSize = 64 * 1024
+
+type mpegFrame struct {
+	mpeg1      bool
+	layer      int
+	bitRate    int
+	sampleRate int
+	channels   int
+	// Samples per frame
+	samples int
+	// Bytes
+	size int
+}
+
+func parseMPEGFrame(header []byte) (mpegFrame, bool) {
+	if len(header) < 4 || header[0] != 0xff || header[1]&0xe0 != 0xe0 {
+		return mpegFrame{}, false
+	}
+
+	versionBits := header[1] >> 3 & 0x3
+	layerBits := header[1] >> 1 & 0x3
+	bitRateIndex := header[2] >> 4
+	sampleRateIndex := header[2] >> 2 & 0x3
+	padding := int(header[2] >> 1 & 0x1)
+
+	if versionBits == 1 || layerBits == 0 || bitRateIndex == 0 || bitRateIndex == 15 || sampleRateIndex == 3 {
+		return mpegFrame{}, false
+	}
+
+	frame := mpegFrame{
+		mpeg1:      versionBits == 3,
+		layer:      4 - int(layerBits),
+		sampleRate: []int{44100, 48000, 32000}[sampleRateIndex],
+		channels:   2,
+	}
+
+	switch versionBits {
+	case 2:
+		frame.sampleRate /= 2
+	case 0:
+		frame.sampleRate /= 4
+	}
+
+	if frame.mpeg1 {
+		frame.bitRate = mpeg1BitRates[frame.layer-1][bitRateIndex] * 1000
+	} else {
+		frame.bitRate = mpeg2BitRates[frame.layer-1][bitRateIndex] * 1000
+	}
+
+	if header[3]>>6 == 3 {
+		frame.channels = 1
+	}
+
+	switch {
+	case frame.layer == 1:
+		frame.samples = 384
+		frame.size = (12*frame.bitRate/frame.sampleRate + padding) * 4
+	case frame.layer == 3 && !frame.mpeg1:
+		frame.samples = 576
+		frame.size = 72*frame.bitRate/frame.sampleRate + padding
+	default:
+		frame.samples = 1152
+		frame.size = 144*frame.bitRate/frame.sampleRate + padding
+	}
+
+	return frame, true
+}
+
+func isMPEGFrame(header []byte) bool {
+	_, ok := parseMPEGFrame(header)
+	return ok
+}
+
+// vbrFrames reads the number of frames from the Xing or VBRI header in
+// the first frame, returns 0 when the file has no such header
+func vbrFrames(data []byte, frame mpegFrame) int {
+	sideInfo := 17
+	if frame.mpeg1 && frame.channels == 2 {
+		sideInfo = 32
+	} else if !frame.mpeg1 && frame.channels == 1 {
+		sideInfo = 9
+	}
+
+	// NOTE(patrik): LAME writes "Info" instead of "Xing" for CBR files
+	offset := 4 + sideInfo
+	if len(data) >= offset+12 {
+		tag := string(data[offset : offset+4])
+		if (tag == "Xing" || tag == "Info") && binary.BigEndian.Uint32(data[offset+4:])&0x1 != 0 {
+			return int(binary.BigEndian.Uint32(data[offset+8:]))
+		}
+	}
+
+	if len(data) >= 36+18 && string(data[36:40]) == "VBRI" {
+		return int(binary.BigEndian.Uint32(data[36+14:]))
+	}
+
+	return 0
+}
+
+func probeMP3(r io.ReadSeeker, size int64) (ProbeResult, error) {
+	tags := make(nativeTags)
+
+	_, err := r.Seek(0, io.SeekStart)
+	if err != nil {
+		return ProbeResult{}, err
+	}
+
+	header := make([]byte, 10)
+	_, err = io.ReadFull(r, header)
+	if err != nil {
+		return ProbeResult{}, err
+	}
+
+	var pictures []PictureStream
+
+	start := id3v2Size(header)
+	if start > size {
+		return ProbeResult{}, fmt.Errorf("Malformed ID3v2 tag")
+	}
+
+	if start > 0 {
+		tag := make([]byte, start)
+		copy(tag, header)
+
+		_, err := io.ReadFull(r, tag[len(header):])
+		if err != nil {
+			return ProbeResult{}, err
+		}
+
+		pictures, err = readID3v2(tag, tags)
+		if err != nil {
+			return ProbeResult{}, err
+		}
+	}
+
+	end := size
+	if size-start >= 128 {
+		tag := make([]byte, 128)
+		_, err := r.Seek(size-128, io.SeekStart)
+		if err != nil {
+			return ProbeResult{}, err
+		}
+
+		_, err = io.ReadFull(r, tag)
+		if err != nil {
+			return ProbeResult{}, err
+		}
+
+		if string(tag[:3]) == "TAG" {
+			readID3v1(tag, tags)
+			end -= 128
+		}
+	}
+
+	_, err = r.Seek(start, io.SeekStart)
+	if err != nil {
+		return ProbeResult{}, err
+	}
+
+	data := make([]byte, min(mpegSearchSize, end-start))
+	_, err = io.ReadFull(r, data)
+	if err != nil {
+		return ProbeResult{}, err
+	}
+
+	offset := -1
+	var frame mpegFrame
+	for i := 0; i+4 <= len(data); i++ {
+		f, ok := parseMPEGFrame(data[i:])
+		if !ok {
+			continue
+		}
+
+		// NOTE(patrik): Random data looks like a frame header often
+		// enough, so the next frame has to follow this one
+		next := i + f.size
+		if next+4 <= len(data) && !isMPEGFrame(data[next:]) {
+			continue
+		}
+
+		offset = i
+		frame = f
+		break
+	}
+
+	if offset == -1 {
+		return ProbeResult{}, fmt.Errorf("No MPEG audio frame found")
+	}
+
+	audio := AudioStream{
+		Index:      0,
+		Codec:      fmt.Sprintf("mp%v", frame.layer),
+		SampleRate: frame.sampleRate,
+		Channels:   frame.channels,
+		BitRate:    frame.bitRate,
+	}
+
+	audioSize := end - start - int64(offset)
+	if frames := vbrFrames(data[offset:], frame); frames > 0 {
+		audio.Duration = float64(frames*frame.samples) / float64(frame.sampleRate)
+		audio.BitRate = int(float64(audioSize*8) / audio.Duration)
+	} else {
+		audio.Duration = float64(audioSize*8) / float64(frame.bitRate)
+	}
+
+	return tags.result("mp3", size, audio, pictures), nil
+}
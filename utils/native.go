@@ -0,0 +1,207 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf16"
+)
+
+var ErrUnsupportedFormat = errors.New("unsupported format")
+
+// Native reads the metadata without any external programs, it supports
+// FLAC, MP3 (ID3v2 and ID3v1 tags), MP4/M4A and WAV files.
+//
+// NOTE(patrik): The stream indexes follow the order ffmpeg uses so the
+// pictures can still be extracted with ffmpeg
+type Native struct{}
+
+func (Native) Probe(filepath string) (ProbeResult, error) {
+	file, err := os.Open(filepath)
+	if err != nil {
+		return ProbeResult{}, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return ProbeResult{}, err
+	}
+
+	result, err := probeNative(file, stat.Size())
+	if err != nil {
+		return ProbeResult{}, fmt.Errorf("Failed to read '%v': %w", filepath, err)
+	}
+
+	return result, nil
+}
+
+func probeNative(r io.ReadSeeker, size int64) (ProbeResult, error) {
+	header := make([]byte, 12)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return ProbeResult{}, ErrUnsupportedFormat
+	}
+
+	switch {
+	case string(header[4:8]) == "ftyp":
+		return probeMP4(r, size)
+	case string(header[:4]) == "RIFF" && string(header[8:12]) == "WAVE":
+		return probeWav(r, size)
+	case string(header[:4]) == "fLaC":
+		_, err := r.Seek(4, io.SeekStart)
+		if err != nil {
+			return ProbeResult{}, err
+		}
+
+		return probeFlac(r, size)
+	case string(header[:3]) == "ID3":
+		// NOTE(patrik): Some taggers put an ID3v2 tag in front of FLAC
+		// files as well
+		_, err := r.Seek(id3v2Size(header), io.SeekStart)
+		if err != nil {
+			return ProbeResult{}, err
+		}
+
+		magic := make([]byte, 4)
+		_, err = io.ReadFull(r, magic)
+		if err == nil && string(magic) == "fLaC" {
+			return probeFlac(r, size)
+		}
+
+		return probeMP3(r, size)
+	case isMPEGFrame(header):
+		return probeMP3(r, size)
+	}
+
+	return ProbeResult{}, ErrUnsupportedFormat
+}
+
+// nativeTags are the tags of a file using the names ffprobe uses (e.g.
+// "album_artist" and "track")
+type nativeTags map[string]string
+
+// set stores the tag, only the first value is used when a tag is set more
+// then once
+func (tags nativeTags) set(key, value string) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return
+	}
+
+	if _, exists := tags[key]; exists {
+		return
+	}
+
+	tags[key] = value
+}
+
+func (tags nativeTags) result(format string, size int64, audio AudioStream, pictures []PictureStream) ProbeResult {
+	bitRate := 0
+	if audio.Duration > 0 {
+		bitRate = int(float64(size*8) / audio.Duration)
+	}
+
+	return ProbeResult{
+		Artist:      tags["artist"],
+		AlbumArtist: tags["album_artist"],
+		Title:       tags["title"],
+		Album:       tags["album"],
+		Track:       getNumberFromFormatString(tags["track"]),
+		Disc:        getNumberFromFormatString(tags["disc"]),
+		Duration:    audio.Duration,
+		Format:      format,
+		BitRate:     bitRate,
+		Streams:     []AudioStream{audio},
+		Pictures:    pictures,
+	}
+}
+
+// pictureCodec returns the name ffmpeg uses for the codec of a picture
+// with the mime type mime
+func pictureCodec(mime string) string {
+	mime = strings.ToLower(mime)
+	switch mime {
+	case "image/jpeg", "image/jpg":
+		return "mjpeg"
+	}
+
+	return strings.TrimPrefix(mime, "image/")
+}
+
+// pictureHash returns the hash stored in PictureStream.Hash
+func pictureHash(data []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(data))
+}
+
+func pictureSize(data []byte) (int, int) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return 0, 0
+	}
+
+	return config.Width, config.Height
+}
+
+func decodeLatin1(data []byte) string {
+	runes := make([]rune, 0, len(data))
+	for _, b := range data {
+		runes = append(runes, rune(b))
+	}
+
+	return string(runes)
+}
+
+func decodeUTF16(data []byte, order binary.ByteOrder) string {
+	units := make([]uint16, 0, len(data)/2)
+	for i := 0; i+1 < len(data); i += 2 {
+		units = append(units, order.Uint16(data[i:]))
+	}
+
+	return string(utf16.Decode(units))
+}
+
+// buffer reads values from a block of metadata, reading past the end
+// returns zero values and sets overflow
+type buffer struct {
+	data     []byte
+	overflow bool
+}
+
+func (buf *buffer) bytes(n int) []byte {
+	if n < 0 || n > len(buf.data) {
+		buf.overflow = true
+		buf.data = nil
+		return nil
+	}
+
+	res := buf.data[:n]
+	buf.data = buf.data[n:]
+	return res
+}
+
+func (buf *buffer) u32be() int {
+	data := buf.bytes(4)
+	if data == nil {
+		return 0
+	}
+
+	return int(binary.BigEndian.Uint32(data))
+}
+
+func (buf *buffer) u32le() int {
+	data := buf.bytes(4)
+	if data == nil {
+		return 0
+	}
+
+	return int(binary.LittleEndian.Uint32(data))
+}
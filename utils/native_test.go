@@ -0,0 +1,219 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestNativeProbe(t *testing.T) {
+	tests := []struct {
+		file        string
+		title       string
+		artist      string
+		albumArtist string
+		album       string
+		track       int
+		disc        int
+		duration    float64
+		codec       string
+		pictures    []PictureStream
+	}{
+		{
+			file:        "tags.flac",
+			title:       "Flac Title",
+			artist:      "Flac Artist",
+			albumArtist: "Flac Album Artist",
+			album:       "Flac Album",
+			track:       3,
+			disc:        2,
+			duration:    3,
+			codec:       "flac",
+			pictures: []PictureStream{
+				{Index: 1, Codec: "png", Width: 4, Height: 3, Front: true, Hash: "e7fa26235ccee3f2c64e62bf424478f6177929a8a29b3c5c3cb4e033b519a496"},
+			},
+		},
+		{
+			// NOTE(patrik): The album and track are only in the ID3v1 tag
+			file:     "id3v23.mp3",
+			title:    "Mp3 Títle",
+			artist:   "Mp3 Artist",
+			album:    "V1 Album",
+			track:    7,
+			disc:     1,
+			duration: 0.52125,
+			codec:    "mp3",
+			pictures: []PictureStream{
+				{Index: 1, Codec: "png", Width: 2, Height: 2, Front: true, Hash: "d70102d681737e33d9da908f52a5d06c689ab61511e021bdfb4e2803526635e3"},
+			},
+		},
+		{
+			// NOTE(patrik): The ID3v2 tag wins over the ID3v1 tag
+			file:        "id3v24.mp3",
+			title:       "V24 Title",
+			artist:      "V24 Artist",
+			albumArtist: "V24 Album Artist",
+			album:       "V24 Album",
+			track:       5,
+			disc:        -1,
+			duration:    0.52125,
+			codec:       "mp3",
+		},
+		{
+			file:        "tags.m4a",
+			title:       "Mp4 Title",
+			artist:      "Mp4 Artist",
+			albumArtist: "Mp4 Album Artist",
+			album:       "Mp4 Album",
+			track:       4,
+			disc:        1,
+			duration:    2,
+			codec:       "aac",
+			pictures: []PictureStream{
+				{Index: 1, Codec: "png", Width: 5, Height: 4, Hash: "8de7ad4fc067e7e9cf1e1103dd777224e192d72a908a9f0f19e159e704586501"},
+			},
+		},
+		{
+			file:     "tags.wav",
+			title:    "Wav Title",
+			artist:   "Wav Artist",
+			album:    "Wav Album",
+			track:    6,
+			disc:     -1,
+			duration: 0.25,
+			codec:    "pcm_s16le",
+		},
+		{
+			// NOTE(patrik): WAVE_FORMAT_EXTENSIBLE with the tags in an ID3
+			// chunk after the odd sized data chunk
+			file:        "extensible.wav",
+			title:       "Id3 Title",
+			artist:      "Id3 Artist",
+			albumArtist: "Id3 Album Artist",
+			album:       "Id3 Album",
+			track:       2,
+			disc:        1,
+			duration:    0.5,
+			codec:       "pcm_s24le",
+			pictures: []PictureStream{
+				{Index: 1, Codec: "png", Width: 3, Height: 2, Front: true, Hash: "c5f771ad04357b7a12516c39e91f38731f63e65db889416fc0a7740adab8f957"},
+			},
+		},
+		{
+			// NOTE(patrik): trkn and disk are 0 when the number is missing
+			file:        "notrack.m4a",
+			title:       "Mp4 Title",
+			artist:      "Mp4 Artist",
+			albumArtist: "Mp4 Album Artist",
+			album:       "Mp4 Album",
+			track:       -1,
+			disc:        -1,
+			duration:    2,
+			codec:       "aac",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.file, func(t *testing.T) {
+			res, err := Native{}.Probe(path.Join("testdata", test.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if res.Title != test.title {
+				t.Errorf("Title = %q, want %q", res.Title, test.title)
+			}
+
+			if res.Artist != test.artist {
+				t.Errorf("Artist = %q, want %q", res.Artist, test.artist)
+			}
+
+			if res.AlbumArtist != test.albumArtist {
+				t.Errorf("AlbumArtist = %q, want %q", res.AlbumArtist, test.albumArtist)
+			}
+
+			if res.Album != test.album {
+				t.Errorf("Album = %q, want %q", res.Album, test.album)
+			}
+
+			if res.Track != test.track {
+				t.Errorf("Track = %v, want %v", res.Track, test.track)
+			}
+
+			if res.Disc != test.disc {
+				t.Errorf("Disc = %v, want %v", res.Disc, test.disc)
+			}
+
+			if math.Abs(res.Duration-test.duration) > 0.001 {
+				t.Errorf("Duration = %v, want %v", res.Duration, test.duration)
+			}
+
+			audio, ok := res.Audio()
+			if !ok || audio.Codec != test.codec {
+				t.Errorf("Audio = %v, want codec %q", audio.Codec, test.codec)
+			}
+
+			if !reflect.DeepEqual(res.Pictures, test.pictures) {
+				t.Errorf("Pictures = %+v, want %+v", res.Pictures, test.pictures)
+			}
+		})
+	}
+}
+
+func TestNativeProbeUnsupported(t *testing.T) {
+	_, err := Native{}.Probe("native_test.go")
+	if !errors.Is(err, ErrUnsupportedFormat) {
+		t.Fatalf("err = %v, want ErrUnsupportedFormat", err)
+	}
+}
+
+func TestNativeProbeWavStream(t *testing.T) {
+	tests := []struct {
+		file  string
+		audio AudioStream
+	}{
+		{"tags.wav", AudioStream{Codec: "pcm_s16le", SampleRate: 44100, BitDepth: 16, Channels: 2, BitRate: 1411200, Duration: 0.25}},
+		{"extensible.wav", AudioStream{Codec: "pcm_s24le", SampleRate: 48000, BitDepth: 24, Channels: 1, BitRate: 1152000, Duration: 72003.0 / 144000}},
+	}
+
+	for _, test := range tests {
+		res, err := Native{}.Probe(path.Join("testdata", test.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		audio, ok := res.Audio()
+		if !ok || audio != test.audio {
+			t.Errorf("%v: Audio = %+v, want %+v", test.file, audio, test.audio)
+		}
+
+		if !audio.Lossless() {
+			t.Errorf("%v: Lossless = false, want true", test.file)
+		}
+	}
+}
+
+func TestNativeProbeWavUnsupported(t *testing.T) {
+	// NOTE(patrik): IMA ADPCM, only PCM is read natively
+	format := make([]byte, 16)
+	binary.LittleEndian.PutUint16(format[0:], 0x11)
+	binary.LittleEndian.PutUint16(format[2:], 2)
+	binary.LittleEndian.PutUint32(format[4:], 44100)
+	binary.LittleEndian.PutUint16(format[14:], 4)
+
+	var b bytes.Buffer
+	b.WriteString("RIFF")
+	binary.Write(&b, binary.LittleEndian, uint32(4+8+len(format)))
+	b.WriteString("WAVEfmt ")
+	binary.Write(&b, binary.LittleEndian, uint32(len(format)))
+	b.Write(format)
+
+	_, err := probeNative(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if !errors.Is(err, ErrUnsupportedFormat) {
+		t.Fatalf("err = %v, want ErrUnsupportedFormat", err)
+	}
+}
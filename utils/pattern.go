@@ -0,0 +1,168 @@
+package utils
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DefaultFilenamePatterns are tried when no patterns are given, they
+// cover "01 - Title", "01. Title", "01 Title" and "track01.cdda"
+var DefaultFilenamePatterns = []string{
+	"{track} - {title}",
+	"{track}. {title}",
+	"{track} {title}",
+	"track {track}{*}",
+}
+
+var placeholderRegex = regexp.MustCompile(`\{([^{}]*)\}`)
+
+var placeholders = map[string]string{
+	"disc":   `(?P<disc>\d+)`,
+	"track":  `(?P<track>\d+)`,
+	"artist": `(?P<artist>.+?)`,
+	"album":  `(?P<album>.+?)`,
+	"title":  `(?P<title>.+?)`,
+	"*":      `.*?`,
+}
+
+// FilenamePattern matches track filenames against a template like
+// "{disc}-{track} - {artist} - {title}", the extension is not part of
+// the match and spaces in the template match any amount of whitespace
+type FilenamePattern struct {
+	Template string
+	regex    *regexp.Regexp
+}
+
+type FilenameMatch struct {
+	Disc   int
+	Track  int
+	Artist string
+	Album  string
+	Title  string
+}
+
+var spaceRegex = regexp.MustCompile(`\s+`)
+
+// quoteLiteral escapes the text between placeholders, whitespace matches
+// any amount of whitespace so "01 - Title" and "01-Title" both match
+// "{track} - {title}"
+func quoteLiteral(literal string) string {
+	var b strings.Builder
+
+	last := 0
+	for _, loc := range spaceRegex.FindAllStringIndex(literal, -1) {
+		b.WriteString(regexp.QuoteMeta(literal[last:loc[0]]))
+		b.WriteString(`\s*`)
+		last = loc[1]
+	}
+
+	b.WriteString(regexp.QuoteMeta(literal[last:]))
+	return b.String()
+}
+
+func ParseFilenamePattern(template string) (*FilenamePattern, error) {
+	var b strings.Builder
+	b.WriteString(`(?i)^`)
+
+	seen := make(map[string]bool)
+	last := 0
+	for _, loc := range placeholderRegex.FindAllStringSubmatchIndex(template, -1) {
+		name := template[loc[2]:loc[3]]
+
+		expr, ok := placeholders[name]
+		if !ok {
+			return nil, fmt.Errorf("Unknown placeholder '{%v}' in pattern '%v' (expected disc, track, artist, album, title or *)", name, template)
+		}
+
+		if name != "*" && seen[name] {
+			return nil, fmt.Errorf("Placeholder '{%v}' used more then once in pattern '%v'", name, template)
+		}
+		seen[name] = true
+
+		b.WriteString(quoteLiteral(template[last:loc[0]]))
+		b.WriteString(expr)
+		last = loc[1]
+	}
+
+	b.WriteString(quoteLiteral(template[last:]))
+	b.WriteString(`$`)
+
+	regex, err := regexp.Compile(b.String())
+	if err != nil {
+		return nil, fmt.Errorf("Invalid pattern '%v': %w", template, err)
+	}
+
+	return &FilenamePattern{
+		Template: template,
+		regex:    regex,
+	}, nil
+}
+
+func ParseFilenamePatterns(templates []string) ([]*FilenamePattern, error) {
+	patterns := make([]*FilenamePattern, 0, len(templates))
+	for _, template := range templates {
+		pattern, err := ParseFilenamePattern(template)
+		if err != nil {
+			return nil, err
+		}
+
+		patterns = append(patterns, pattern)
+	}
+
+	return patterns, nil
+}
+
+// Match matches name, which should be the filename without the
+// extension. Numbers not in the template are returned as -1
+func (pattern *FilenamePattern) Match(name string) (FilenameMatch, bool) {
+	res := pattern.regex.FindStringSubmatch(name)
+	if res == nil {
+		return FilenameMatch{}, false
+	}
+
+	match := FilenameMatch{
+		Disc:  -1,
+		Track: -1,
+	}
+
+	for i, group := range pattern.regex.SubexpNames() {
+		value := strings.TrimSpace(res[i])
+
+		switch group {
+		case "disc":
+			match.Disc, _ = strconv.Atoi(value)
+		case "track":
+			match.Track, _ = strconv.Atoi(value)
+		case "artist":
+			match.Artist = value
+		case "album":
+			match.Album = value
+		case "title":
+			match.Title = value
+		}
+	}
+
+	return match, true
+}
+
+// MatchFilename matches the filename without its extension against the
+// patterns, the first pattern that matches is used
+func MatchFilename(filename string, patterns []*FilenamePattern) (FilenameMatch, *FilenamePattern, error) {
+	name := strings.TrimSuffix(filename, path.Ext(filename))
+
+	for _, pattern := range patterns {
+		if match, ok := pattern.Match(name); ok {
+			return match, pattern, nil
+		}
+	}
+
+	templates := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		templates = append(templates, fmt.Sprintf("%q", pattern.Template))
+	}
+
+	return FilenameMatch{}, nil, fmt.Errorf("No filename pattern matched '%v', tried: %v", filename, strings.Join(templates, ", "))
+}
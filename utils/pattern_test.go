@@ -0,0 +1,97 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMatchFilename(t *testing.T) {
+	tests := []struct {
+		templates []string
+		filename  string
+		template  string
+		match     FilenameMatch
+	}{
+		// NOTE(patrik): The two shapes the old hardcoded regexes handled,
+		// "NN - name" and "trackNN"
+		{DefaultFilenamePatterns, "01 - Enter Sandman.flac", "{track} - {title}", FilenameMatch{Disc: -1, Track: 1, Title: "Enter Sandman"}},
+		{DefaultFilenamePatterns, "02-Sad but True.mp3", "{track} - {title}", FilenameMatch{Disc: -1, Track: 2, Title: "Sad but True"}},
+		{DefaultFilenamePatterns, "03 Holier Than Thou.flac", "{track} {title}", FilenameMatch{Disc: -1, Track: 3, Title: "Holier Than Thou"}},
+		{DefaultFilenamePatterns, "track01.cdda.wav", "track {track}{*}", FilenameMatch{Disc: -1, Track: 1}},
+		{DefaultFilenamePatterns, "Track 12.wav", "track {track}{*}", FilenameMatch{Disc: -1, Track: 12}},
+
+		{
+			[]string{"{disc}-{track} - {artist} - {title}", "{track}. {title}"},
+			"2-07 - Metallica - Through the Never.flac",
+			"{disc}-{track} - {artist} - {title}",
+			FilenameMatch{Disc: 2, Track: 7, Artist: "Metallica", Title: "Through the Never"},
+		},
+		{
+			[]string{"{disc}-{track} - {artist} - {title}", "{track}. {title}"},
+			"08. Nothing Else Matters.mp3",
+			"{track}. {title}",
+			FilenameMatch{Disc: -1, Track: 8, Title: "Nothing Else Matters"},
+		},
+
+		// NOTE(patrik): Regex characters in the template are literals
+		{[]string{"[{track}] {title}"}, "[05] Wherever I May Roam.flac", "[{track}] {title}", FilenameMatch{Disc: -1, Track: 5, Title: "Wherever I May Roam"}},
+		{[]string{"{album} ({disc}) {track}+{title}"}, "Metallica (1) 06+Don't Tread on Me.flac", "{album} ({disc}) {track}+{title}", FilenameMatch{Disc: 1, Track: 6, Album: "Metallica", Title: "Don't Tread on Me"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.filename, func(t *testing.T) {
+			patterns, err := ParseFilenamePatterns(test.templates)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			match, pattern, err := MatchFilename(test.filename, patterns)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if pattern.Template != test.template {
+				t.Errorf("Template = %q, want %q", pattern.Template, test.template)
+			}
+
+			if match != test.match {
+				t.Errorf("Match = %+v, want %+v", match, test.match)
+			}
+		})
+	}
+}
+
+func TestMatchFilenameNoMatch(t *testing.T) {
+	patterns, err := ParseFilenamePatterns([]string{"{track}. {title}", "{disc}-{track} - {title}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// NOTE(patrik): "." in the template only matches a literal dot
+	_, _, err = MatchFilename("03x Holier Than Thou.flac", patterns)
+	if err == nil {
+		t.Fatal("Expected an error")
+	}
+
+	want := `No filename pattern matched '03x Holier Than Thou.flac', tried: "{track}. {title}", "{disc}-{track} - {title}"`
+	if err.Error() != want {
+		t.Errorf("err = %q, want %q", err.Error(), want)
+	}
+}
+
+func TestParseFilenamePatternInvalid(t *testing.T) {
+	tests := []struct {
+		template string
+		err      string
+	}{
+		{"{track} - {name}", "Unknown placeholder '{name}'"},
+		{"{track} - {track}", "used more then once"},
+	}
+
+	for _, test := range tests {
+		_, err := ParseFilenamePattern(test.template)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("ParseFilenamePattern(%q) = %v, want an error containing %q", test.template, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package utils
+
+import "fmt"
+
+const (
+	ProberFFprobe = "ffprobe"
+	ProberNative  = "native"
+)
+
+// Prober reads the tags and the technical metadata of an audio file
+type Prober interface {
+	Probe(filepath string) (ProbeResult, error)
+}
+
+// DefaultProber is used by ProbeFile and CheckFile
+var DefaultProber Prober = FFprobe{}
+
+// NewProber returns the prober called name, either ProberFFprobe or
+// ProberNative
+func NewProber(name string) (Prober, error) {
+	switch name {
+	case ProberFFprobe:
+		return FFprobe{}, nil
+	case ProberNative:
+		return Native{}, nil
+	default:
+		return nil, fmt.Errorf("Unknown prober '%v' (expected ffprobe or native)", name)
+	}
+}
+
+// ProbeFile reads the tags and the duration of a file with DefaultProber
+func ProbeFile(filepath string) (ProbeResult, error) {
+	return DefaultProber.Probe(filepath)
+}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -23,7 +24,13 @@ func RunFFprobe(args ...string) ([]byte, error) {
 }
 
 func RunFFmpeg(verbose bool, args ...string) error {
-	cmd := exec.Command("ffmpeg", args...)
+	return RunFFmpegContext(context.Background(), verbose, args...)
+}
+
+// RunFFmpegContext runs ffmpeg and kills the process if ctx is done
+// before it exits
+func RunFFmpegContext(ctx context.Context, verbose bool, args ...string) error {
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
 	if verbose {
 		cmd.Stdout = os.Stdout
 		cmd.Stderr = os.Stderr
@@ -37,6 +44,80 @@ func RunFFmpeg(verbose bool, args ...string) error {
 	return nil
 }
 
+// AudioStream is the technical info of one audio stream, the numbers are
+// 0 when ffprobe doesn't know them (e.g. bit depth of lossy codecs)
+type AudioStream struct {
+	Index      int    `json:"index"`
+	Codec      string `json:"codec"`
+	SampleRate int    `json:"sampleRate"`
+	BitDepth   int    `json:"bitDepth"`
+	Channels   int    `json:"channels"`
+	// Bits per second
+	BitRate int `json:"bitRate"`
+	// Seconds
+	Duration float64 `json:"duration"`
+}
+
+// Lossless checks if the codec of the stream is lossless
+func (stream *AudioStream) Lossless() bool {
+	switch stream.Codec {
+	case "flac", "alac", "wavpack", "ape", "tta", "truehd", "mlp":
+		return true
+	}
+
+	return strings.HasPrefix(stream.Codec, "pcm_")
+}
+
+func (stream *AudioStream) String() string {
+	parts := []string{stream.Codec}
+
+	if stream.SampleRate > 0 {
+		parts = append(parts, fmt.Sprintf("%v Hz", stream.SampleRate))
+	}
+
+	if stream.BitDepth > 0 {
+		parts = append(parts, fmt.Sprintf("%v bit", stream.BitDepth))
+	}
+
+	if stream.Channels > 0 {
+		parts = append(parts, fmt.Sprintf("%v ch", stream.Channels))
+	}
+
+	if stream.BitRate > 0 {
+		parts = append(parts, fmt.Sprintf("%v kbps", stream.BitRate/1000))
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// PictureStream is an image attached to the file, e.g. the cover stored
+// in the tags
+type PictureStream struct {
+	Index  int    `json:"index"`
+	Codec  string `json:"codec"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	// The picture is marked as the front cover
+	Front bool `json:"front"`
+	// NOTE(patrik): Hash is the sha256 of the picture data, it's empty
+	// when the picture couldn't be read without ffmpeg
+	Hash string `json:"hash,omitempty"`
+}
+
+func (picture *PictureStream) String() string {
+	return fmt.Sprintf("%v, %vx%v", picture.Codec, picture.Width, picture.Height)
+}
+
+// Ext returns the file extension the picture is stored with when it's
+// extracted
+func (picture *PictureStream) Ext() string {
+	if picture.Codec == "mjpeg" {
+		return "jpg"
+	}
+
+	return "png"
+}
+
 type ProbeResult struct {
 	Artist      string
 	AlbumArtist string
@@ -44,6 +125,41 @@ type ProbeResult struct {
 	Album       string
 	Track       int
 	Disc        int
+
+	// Duration in seconds, 0 if unknown
+	Duration float64
+	// Container format, e.g. "flac" or "mov,mp4,m4a,3gp,3g2,mj2"
+	Format string
+	// Bits per second for the whole file, 0 if unknown
+	BitRate int
+
+	Streams  []AudioStream
+	Pictures []PictureStream
+}
+
+// Audio returns the first audio stream
+func (probe *ProbeResult) Audio() (AudioStream, bool) {
+	if len(probe.Streams) == 0 {
+		return AudioStream{}, false
+	}
+
+	return probe.Streams[0], true
+}
+
+// Cover returns the front cover attached to the file, files with
+// pictures that are not marked as the front cover use the first one
+func (probe *ProbeResult) Cover() (PictureStream, bool) {
+	if len(probe.Pictures) == 0 {
+		return PictureStream{}, false
+	}
+
+	for _, picture := range probe.Pictures {
+		if picture.Front {
+			return picture, true
+		}
+	}
+
+	return probe.Pictures[0], true
 }
 
 type FileResult struct {
@@ -51,13 +167,20 @@ type FileResult struct {
 	Number int
 	Name   string
 
+	// NOTE(patrik): From the filename pattern, empty or -1 when the
+	// pattern doesn't have them
+	Disc   int
+	Artist string
+	Album  string
+
 	Probe ProbeResult
 }
 
-
 type probeFormat struct {
-	BitRate string `json:"bit_rate"`
-	Tags    struct {
+	FormatName string `json:"format_name"`
+	BitRate    string `json:"bit_rate"`
+	Duration   string `json:"duration"`
+	Tags       struct {
 		Album       string `json:"album"`
 		AlbumArtist string `json:"album_artist"`
 		Artist      string `json:"artist"`
@@ -70,10 +193,8 @@ type probeFormat struct {
 	// "filename": "/Volumes/media/music/Various Artists/Cyberpunk 2077/cd1/19 - P.T. Adamczyk - Rite Of Passage.mp3",
 	// "nb_streams": 2,
 	// "nb_programs": 0,
-	// "format_name": "mp3",
 	// "format_long_name": "MP2/3 (MPEG audio layer 2/3)",
 	// "start_time": "0.025056",
-	// "duration": "334.915918",
 	// "size": "13898147",
 	// "probe_score": 51,
 }
@@ -83,6 +204,14 @@ type probeStream struct {
 	CodecName string `json:"codec_name"`
 	CodecType string `json:"codec_type"`
 
+	// Audio
+	SampleRate       string `json:"sample_rate"`
+	Channels         int    `json:"channels"`
+	BitsPerSample    int    `json:"bits_per_sample"`
+	BitsPerRawSample string `json:"bits_per_raw_sample"`
+	BitRate          string `json:"bit_rate"`
+	Duration         string `json:"duration"`
+
 	// Video
 	Width  int `json:"width"`
 	Height int `json:"height"`
@@ -130,7 +259,6 @@ type probeStream struct {
 	// "start_pts": 2255,
 	// "start_time": "0.025056",
 	// "duration_ts": 30142433,
-	// "duration": "334.915922",
 }
 
 type probe struct {
@@ -138,6 +266,25 @@ type probe struct {
 	Format  probeFormat   `json:"format"`
 }
 
+// parseInt parses the numbers ffprobe sends as strings, 0 if unknown
+func parseInt(s string) int {
+	num, err := strconv.Atoi(s)
+	if err != nil {
+		return 0
+	}
+
+	return num
+}
+
+func parseFloat(s string) float64 {
+	num, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0
+	}
+
+	return num
+}
+
 func getNumberFromFormatString(s string) int {
 	if strings.Contains(s, "/") {
 		s = strings.Split(s, "/")[0]
@@ -151,77 +298,169 @@ func getNumberFromFormatString(s string) int {
 	return num
 }
 
-// TODO(patrik): Update to not include file extentions
-var test1 = regexp.MustCompile(`(^\d+)[-\s]*(.+)\.`)
-var test2 = regexp.MustCompile(`track(\d+).+`)
+// FFprobe reads the metadata by running ffprobe, it supports every
+// format ffmpeg does
+type FFprobe struct{}
 
-// TODO(patrik): Fix this function
-func CheckFile(filepath string) (FileResult, error) {
+func (FFprobe) Probe(filepath string) (ProbeResult, error) {
 	// ffprobe -v quiet -print_format json -show_format -show_streams input
 
 	data, err := RunFFprobe("-v", "quiet", "-print_format", "json", "-show_format", "-show_streams", filepath)
 	if err != nil {
-		fmt.Printf("%v\n", err)
-		return FileResult{}, err
+		return ProbeResult{}, err
 	}
 
-	// fmt.Printf("string(data): %v\n", string(data))
-
 	var probe probe
 	err = json.Unmarshal(data, &probe)
 	if err != nil {
-		return FileResult{}, err
+		return ProbeResult{}, err
 	}
 
-	// fmt.Printf("probe: %+v\n", probe)
-	// probe.Format.Tags.Track
-
 	track := getNumberFromFormatString(probe.Format.Tags.Track)
 	disc := getNumberFromFormatString(probe.Format.Tags.Disc)
 
-	probeResult := ProbeResult{
+	var streams []AudioStream
+	var pictures []PictureStream
+	for _, stream := range probe.Streams {
+		if stream.CodecType == "video" && stream.Disposition.AttachedPic == 1 {
+			pictures = append(pictures, PictureStream{
+				Index:  stream.Index,
+				Codec:  stream.CodecName,
+				Width:  stream.Width,
+				Height: stream.Height,
+				Front:  strings.EqualFold(stream.Tags.Comment, "Cover (front)"),
+			})
+			continue
+		}
+
+		if stream.CodecType != "audio" {
+			continue
+		}
+
+		bitDepth := parseInt(stream.BitsPerRawSample)
+		if bitDepth == 0 {
+			bitDepth = stream.BitsPerSample
+		}
+
+		streams = append(streams, AudioStream{
+			Index:      stream.Index,
+			Codec:      stream.CodecName,
+			SampleRate: parseInt(stream.SampleRate),
+			BitDepth:   bitDepth,
+			Channels:   stream.Channels,
+			BitRate:    parseInt(stream.BitRate),
+			Duration:   parseFloat(stream.Duration),
+		})
+	}
+
+	// NOTE(patrik): ffprobe can't tell if two files embed the same
+	// picture, so the pictures are read again with the native reader for
+	// the formats it supports
+	if len(pictures) > 0 {
+		addPictureHashes(filepath, pictures)
+	}
+
+	duration := parseFloat(probe.Format.Duration)
+	if duration == 0 && len(streams) > 0 {
+		duration = streams[0].Duration
+	}
+
+	return ProbeResult{
 		Artist:      probe.Format.Tags.Artist,
 		AlbumArtist: probe.Format.Tags.AlbumArtist,
 		Title:       probe.Format.Tags.Title,
 		Album:       probe.Format.Tags.Album,
 		Track:       track,
 		Disc:        disc,
+		Duration:    duration,
+		Format:      probe.Format.FormatName,
+		BitRate:     parseInt(probe.Format.BitRate),
+		Streams:     streams,
+		Pictures:    pictures,
+	}, nil
+}
+
+// addPictureHashes fills in the hashes of pictures using the native
+// reader, pictures in formats it doesn't support are left without a hash
+func addPictureHashes(filepath string, pictures []PictureStream) {
+	res, err := Native{}.Probe(filepath)
+	if err != nil {
+		return
 	}
 
-	name := path.Base(filepath)
-	res := test1.FindStringSubmatch(name)
-	if res == nil {
-		res := test2.FindStringSubmatch(name)
-		if res == nil {
-			return FileResult{}, fmt.Errorf("No result")
+	for i := range pictures {
+		for _, picture := range res.Pictures {
+			if picture.Index == pictures[i].Index && picture.Codec == pictures[i].Codec {
+				pictures[i].Hash = picture.Hash
+			}
 		}
+	}
+}
 
+// ExtractPicture writes the attached picture to output, jpeg and png
+// pictures are copied as is and other formats are converted to png
+func ExtractPicture(ctx context.Context, input string, picture PictureStream, output string) error {
+	codec := "png"
+	if picture.Codec == "mjpeg" || picture.Codec == "png" {
+		codec = "copy"
+	}
 
-		num, err := strconv.Atoi(string(res[1]))
-		if err != nil {
-			return FileResult{}, nil
-		}
+	// ffmpeg -i input.flac -map 0:1 -c copy -frames:v 1 output.jpg
+	return RunFFmpegContext(ctx, false, "-y", "-v", "error", "-i", input, "-map", fmt.Sprintf("0:%v", picture.Index), "-c", codec, "-frames:v", "1", output)
+}
 
-		return FileResult{
-			Path:   filepath,
-			Number: num,
-			Name:   "",
-			Probe:  probeResult,
-		}, nil
-	} else {
-		num, err := strconv.Atoi(string(res[1]))
-		if err != nil {
-			return FileResult{}, nil
-		}
+// CheckFile probes the file and matches its name against patterns, the
+// first pattern that matches is used. When the pattern has no {track}
+// the track number from the tags is used instead
+func CheckFile(filepath string, patterns []*FilenamePattern) (FileResult, error) {
+	probeResult, err := ProbeFile(filepath)
+	if err != nil {
+		return FileResult{}, fmt.Errorf("Failed to probe '%v': %w", filepath, err)
+	}
+
+	match, pattern, err := MatchFilename(path.Base(filepath), patterns)
+	if err != nil {
+		return FileResult{}, err
+	}
 
-		name := string(res[2])
-		return FileResult{
-			Path:   filepath,
-			Number: num,
-			Name:   name,
-			Probe:  probeResult,
-		}, nil
+	number := match.Track
+	if number == -1 {
+		number = probeResult.Track
 	}
+
+	if number <= 0 {
+		return FileResult{}, fmt.Errorf("No track number for '%v' (pattern '%v' has no {track} and the file has no track tag)", path.Base(filepath), pattern.Template)
+	}
+
+	return FileResult{
+		Path:   filepath,
+		Number: number,
+		Name:   match.Title,
+		Disc:   match.Disc,
+		Artist: match.Artist,
+		Album:  match.Album,
+		Probe:  probeResult,
+	}, nil
+}
+
+var discDirRegex = regexp.MustCompile(`(?i)^(?:cd|disc|disk)[\s_.-]*(\d+)(?:[\s_.-]*(.*))?$`)
+
+// ParseDiscDir checks if name looks like a disc folder (e.g. "CD1",
+// "Disc 2" or "Disc 2 - Bonus") and returns the disc number and the
+// optional subtitle
+func ParseDiscDir(name string) (int, string, bool) {
+	res := discDirRegex.FindStringSubmatch(name)
+	if res == nil {
+		return 0, "", false
+	}
+
+	num, err := strconv.Atoi(res[1])
+	if err != nil {
+		return 0, "", false
+	}
+
+	subtitle := strings.TrimSpace(res[2])
+	return num, subtitle, true
 }
 
 var validExts []string = []string{
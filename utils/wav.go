@@ -0,0 +1,187 @@
+package utils
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	wavFormatPCM        = 0x0001
+	wavFormatFloat      = 0x0003
+	wavFormatALaw       = 0x0006
+	wavFormatMuLaw      = 0x0007
+	wavFormatExtensible = 0xfffe
+)
+
+// NOTE(patrik): The RIFF INFO tags ffmpeg reads, there is no standard
+// tag for the album artist or the disc
+var wavInfoKeys = map[string]string{
+	"INAM": "title",
+	"IART": "artist",
+	"IPRD": "album",
+	"IPRT": "track",
+	"ITRK": "track",
+}
+
+// wavCodec returns the name ffmpeg uses for the codec of a WAVE file
+// with the format tag and sample size
+func wavCodec(format, bits int) (string, error) {
+	switch format {
+	case wavFormatPCM:
+		switch bits {
+		case 8:
+			return "pcm_u8", nil
+		case 16, 24, 32:
+			return fmt.Sprintf("pcm_s%vle", bits), nil
+		}
+	case wavFormatFloat:
+		switch bits {
+		case 32, 64:
+			return fmt.Sprintf("pcm_f%vle", bits), nil
+		}
+	case wavFormatALaw:
+		return "pcm_alaw", nil
+	case wavFormatMuLaw:
+		return "pcm_mulaw", nil
+	}
+
+	return "", fmt.Errorf("WAVE format 0x%04x with %v bits: %w", format, bits, ErrUnsupportedFormat)
+}
+
+// readWavFmt reads the fmt chunk into audio
+func readWavFmt(data []byte, audio *AudioStream) (int, error) {
+	if len(data) < 16 {
+		return 0, fmt.Errorf("Malformed WAVE fmt chunk")
+	}
+
+	format := int(binary.LittleEndian.Uint16(data[0:]))
+	audio.Channels = int(binary.LittleEndian.Uint16(data[2:]))
+	audio.SampleRate = int(binary.LittleEndian.Uint32(data[4:]))
+	byteRate := int(binary.LittleEndian.Uint32(data[8:]))
+	bits := int(binary.LittleEndian.Uint16(data[14:]))
+
+	// NOTE(patrik): WAVE_FORMAT_EXTENSIBLE stores the real format in the
+	// first two bytes of the sub format GUID
+	if format == wavFormatExtensible && len(data) >= 26 {
+		format = int(binary.LittleEndian.Uint16(data[24:]))
+	}
+
+	codec, err := wavCodec(format, bits)
+	if err != nil {
+		return 0, err
+	}
+
+	audio.Codec = codec
+	audio.BitDepth = bits
+	audio.BitRate = byteRate * 8
+
+	return byteRate, nil
+}
+
+// readWavInfo reads the tags from a LIST INFO chunk, data is the chunk
+// without the "INFO" list type
+func readWavInfo(data []byte, tags nativeTags) {
+	for len(data) >= 8 {
+		id := string(data[:4])
+		length := int(binary.LittleEndian.Uint32(data[4:]))
+		data = data[8:]
+
+		if length > len(data) {
+			return
+		}
+
+		if key, ok := wavInfoKeys[id]; ok {
+			tags.set(key, strings.TrimRight(string(data[:length]), "\x00"))
+		}
+
+		// NOTE(patrik): Chunks are padded to an even size
+		length += length & 1
+		if length > len(data) {
+			return
+		}
+		data = data[length:]
+	}
+}
+
+// probeWav reads the chunks of a RIFF WAVE file, r is positioned right
+// after the "WAVE" marker. Tags are read from a LIST INFO chunk and from
+// an ID3v2 tag stored in an "id3 " chunk
+func probeWav(r io.ReadSeeker, size int64) (ProbeResult, error) {
+	tags := make(nativeTags)
+	audio := AudioStream{
+		Index: 0,
+	}
+
+	var pictures []PictureStream
+	var byteRate int
+	var dataSize int64 = -1
+
+	offset := int64(12)
+	for offset+8 <= size {
+		header := make([]byte, 8)
+		_, err := io.ReadFull(r, header)
+		if err != nil {
+			return ProbeResult{}, fmt.Errorf("Malformed WAVE chunk: %w", err)
+		}
+
+		id := string(header[:4])
+		length := int64(binary.LittleEndian.Uint32(header[4:]))
+		offset += 8
+
+		// NOTE(patrik): Files written while recording can have a bogus
+		// length on the last chunk
+		if length > size-offset {
+			length = size - offset
+		}
+
+		switch id {
+		case "fmt ", "LIST", "id3 ", "ID3 ":
+			data := make([]byte, length)
+			_, err := io.ReadFull(r, data)
+			if err != nil {
+				return ProbeResult{}, fmt.Errorf("Malformed WAVE chunk '%v': %w", id, err)
+			}
+
+			switch id {
+			case "fmt ":
+				byteRate, err = readWavFmt(data, &audio)
+				if err != nil {
+					return ProbeResult{}, err
+				}
+			case "LIST":
+				if len(data) >= 4 && string(data[:4]) == "INFO" {
+					readWavInfo(data[4:], tags)
+				}
+			default:
+				pictures, err = readID3v2(data, tags)
+				if err != nil {
+					return ProbeResult{}, err
+				}
+			}
+		case "data":
+			dataSize = length
+		}
+
+		offset += length + length&1
+		_, err = r.Seek(offset, io.SeekStart)
+		if err != nil {
+			return ProbeResult{}, err
+		}
+	}
+
+	if audio.Codec == "" {
+		return ProbeResult{}, fmt.Errorf("No WAVE fmt chunk found")
+	}
+
+	if dataSize < 0 {
+		return ProbeResult{}, fmt.Errorf("No WAVE data chunk found")
+	}
+
+	if byteRate > 0 {
+		audio.Duration = float64(dataSize) / float64(byteRate)
+	}
+
+	return tags.result("wav", size, audio, pictures), nil
+}
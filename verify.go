@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"path"
+
+	"github.com/nanoteck137/dwebble-importer/musicbrainz"
+	"github.com/nanoteck137/dwebble-importer/utils"
+)
+
+// DurationMismatch is a track where the local file and the matched
+// MusicBrainz track differ by more then the tolerance, durations are in
+// seconds
+type DurationMismatch struct {
+	Disc     int     `json:"disc"`
+	Number   int     `json:"number"`
+	Name     string  `json:"name"`
+	Filename string  `json:"filename"`
+	Local    float64 `json:"local"`
+	Expected float64 `json:"expected"`
+}
+
+func (mismatch *DurationMismatch) String() string {
+	return fmt.Sprintf("%v-%v %v: %v locally, %v on MusicBrainz (%v)",
+		mismatch.Disc, mismatch.Number, mismatch.Name,
+		formatLength(mismatch.Local), formatLength(mismatch.Expected), mismatch.Filename)
+}
+
+// releaseTrackLength returns the length of the track in seconds, the
+// length on the release is preferred since the recording length is an
+// average over every release of the recording
+func releaseTrackLength(track *musicbrainz.Track) float64 {
+	if track.Length > 0 {
+		return float64(track.Length) / 1000
+	}
+
+	return float64(track.Recording.Length) / 1000
+}
+
+func findRecording(metadata *musicbrainz.Metadata, mbid string) (*musicbrainz.Track, bool) {
+	for mi := range metadata.Media {
+		media := &metadata.Media[mi]
+		for ti := range media.Tracks {
+			if media.Tracks[ti].Recording.Id == mbid {
+				return &media.Tracks[ti], true
+			}
+		}
+	}
+
+	return nil, false
+}
+
+// compareDurations checks the local durations against the track lengths
+// of the release. Tracks are matched by recording id when the config has
+// one, so a file with the wrong number still gets compared with the
+// right track
+func compareDurations(config *Config, durations map[string]float64, metadata *musicbrainz.Metadata, tolerance float64) []DurationMismatch {
+	var mismatches []DurationMismatch
+
+	for _, track := range config.Tracks {
+		local := durations[track.Filename]
+		if local <= 0 {
+			continue
+		}
+
+		var releaseTrack *musicbrainz.Track
+		found := false
+		if track.RecordingMbid != "" {
+			releaseTrack, found = findRecording(metadata, track.RecordingMbid)
+		}
+
+		if !found {
+			releaseTrack, found = findReleaseTrack(metadata, track.DiscNumber(), track.Num)
+		}
+
+		if !found {
+			continue
+		}
+
+		expected := releaseTrackLength(releaseTrack)
+		if expected <= 0 {
+			continue
+		}
+
+		if math.Abs(local-expected) > tolerance {
+			mismatches = append(mismatches, DurationMismatch{
+				Disc:     track.DiscNumber(),
+				Number:   track.Num,
+				Name:     track.Name,
+				Filename: track.Filename,
+				Local:    local,
+				Expected: expected,
+			})
+		}
+	}
+
+	return mismatches
+}
+
+func printDurationMismatches(mismatches []DurationMismatch) {
+	for i := range mismatches {
+		fmt.Printf("WARNING: Duration mismatch %v\n", mismatches[i].String())
+	}
+}
+
+// verifyDurations probes every track of the album in dir and compares
+// the durations with the release the config was matched against, albums
+// without a release_mbid are not checked
+func verifyDurations(mb *musicbrainz.Client, dir string, config *Config, tolerance float64) ([]DurationMismatch, error) {
+	if config.ReleaseMbid == "" {
+		return nil, nil
+	}
+
+	metadata, err := mb.FetchAlbumMetadata(config.ReleaseMbid)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch release '%v' for verification: %w", config.ReleaseMbid, err)
+	}
+
+	durations := make(map[string]float64)
+	for _, track := range config.Tracks {
+		probe, err := utils.ProbeFile(path.Join(dir, track.Filename))
+		if err != nil {
+			return nil, fmt.Errorf("Failed to probe '%v': %w", track.Filename, err)
+		}
+
+		durations[track.Filename] = probe.Duration
+	}
+
+	return compareDurations(config, durations, &metadata, tolerance), nil
+}